@@ -0,0 +1,357 @@
+package chain
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ABI is the part of a contract's abi needed to decode its actions.
+type ABI struct {
+	Types    []ABIType    `json:"types"`
+	Structs  []ABIStruct  `json:"structs"`
+	Actions  []ABIAction  `json:"actions"`
+	Variants []ABIVariant `json:"variants"`
+}
+
+type ABIType struct {
+	NewTypeName string `json:"new_type_name"`
+	Type        string `json:"type"`
+}
+
+type ABIStruct struct {
+	Name   string     `json:"name"`
+	Base   string     `json:"base"`
+	Fields []ABIField `json:"fields"`
+}
+
+type ABIField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type ABIAction struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type ABIVariant struct {
+	Name  string   `json:"name"`
+	Types []string `json:"types"`
+}
+
+// AbiResponse is what get_abi answers, Abi is nil for accounts without a contract
+type AbiResponse struct {
+	AccountName string `json:"account_name"`
+	Abi         *ABI   `json:"abi"`
+}
+
+// aliases may point at each other, a cycle stops here
+const abi_max_depth = 32
+
+// ActionFields lists the fields of an action's struct, base fields first
+func (a *ABI) ActionFields(action_name string) []ABIField {
+	for _, action := range a.Actions {
+		if action.Name == action_name {
+			s, ok := a.findStruct(a.resolve(action.Type))
+			if ok {
+				return a.fields(s, 0)
+			}
+		}
+	}
+
+	return nil
+}
+
+// DecodeAction decodes an action's binary data into the
+// same json shape nodeos' abi serializer produces
+func (a *ABI) DecodeAction(action_name string, data []byte) (map[string]interface{}, error) {
+	for _, action := range a.Actions {
+		if action.Name != action_name {
+			continue
+		}
+
+		r := NewReader(data)
+
+		v, err := a.decode(r, action.Type, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		if r.Err() != nil {
+			return nil, r.Err()
+		}
+
+		fields, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("abi: action " + action_name + " is not a struct")
+		}
+
+		return fields, nil
+	}
+
+	return nil, errors.New("abi: unknown action " + action_name)
+}
+
+func (a *ABI) resolve(name string) string {
+	for depth := 0; depth < abi_max_depth; depth++ {
+		found := false
+
+		for _, t := range a.Types {
+			if t.NewTypeName == name {
+				name = t.Type
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			break
+		}
+	}
+
+	return name
+}
+
+func (a *ABI) findStruct(name string) (ABIStruct, bool) {
+	for _, s := range a.Structs {
+		if s.Name == name {
+			return s, true
+		}
+	}
+
+	return ABIStruct{}, false
+}
+
+func (a *ABI) fields(s ABIStruct, depth int) []ABIField {
+	fields := []ABIField{}
+
+	if len(s.Base) > 0 && depth < abi_max_depth {
+		if base, ok := a.findStruct(a.resolve(s.Base)); ok {
+			fields = append(fields, a.fields(base, depth+1)...)
+		}
+	}
+
+	return append(fields, s.Fields...)
+}
+
+func (a *ABI) decode(r *Reader, type_name string, depth int) (interface{}, error) {
+	if depth > abi_max_depth {
+		return nil, errors.New("abi: type nesting too deep at " + type_name)
+	}
+
+	type_name = a.resolve(type_name)
+
+	// binary extensions may be left off the end of the data
+	if strings.HasSuffix(type_name, "$") {
+		if r.Remaining() == 0 {
+			return nil, nil
+		}
+		return a.decode(r, strings.TrimSuffix(type_name, "$"), depth+1)
+	}
+
+	if strings.HasSuffix(type_name, "?") {
+		if !r.Bool() {
+			return nil, r.Err()
+		}
+		return a.decode(r, strings.TrimSuffix(type_name, "?"), depth+1)
+	}
+
+	if strings.HasSuffix(type_name, "[]") {
+		count := r.Varuint32()
+		items := []interface{}{}
+
+		for i := uint32(0); i < count && r.Err() == nil; i++ {
+			item, err := a.decode(r, strings.TrimSuffix(type_name, "[]"), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+
+		return items, r.Err()
+	}
+
+	if v, ok := decodeBuiltin(r, type_name); ok {
+		return v, r.Err()
+	}
+
+	for _, variant := range a.Variants {
+		if variant.Name != type_name {
+			continue
+		}
+
+		i := r.Varuint32()
+		if int(i) >= len(variant.Types) {
+			return nil, errors.New("abi: variant " + type_name + " has no type " + strconv.Itoa(int(i)))
+		}
+
+		v, err := a.decode(r, variant.Types[i], depth+1)
+		return []interface{}{variant.Types[i], v}, err
+	}
+
+	s, ok := a.findStruct(type_name)
+	if !ok {
+		return nil, errors.New("abi: unknown type " + type_name)
+	}
+
+	output := make(map[string]interface{})
+
+	for _, field := range a.fields(s, 0) {
+		v, err := a.decode(r, field.Type, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		// a missing binary extension leaves the field out
+		if v == nil && strings.HasSuffix(field.Type, "$") {
+			continue
+		}
+
+		output[field.Name] = v
+	}
+
+	return output, r.Err()
+}
+
+// decodeBuiltin reads the types every abi can use without declaring them,
+// 64 bit and wider integers are strings like nodeos prints them
+func decodeBuiltin(r *Reader, type_name string) (interface{}, bool) {
+	switch type_name {
+	case "bool":
+		return r.Bool(), true
+	case "int8":
+		return int8(r.Uint8()), true
+	case "uint8":
+		return r.Uint8(), true
+	case "int16":
+		return int16(r.Uint16()), true
+	case "uint16":
+		return r.Uint16(), true
+	case "int32":
+		return int32(r.Uint32()), true
+	case "uint32":
+		return r.Uint32(), true
+	case "int64":
+		return strconv.FormatInt(r.Int64(), 10), true
+	case "uint64":
+		return strconv.FormatUint(r.Uint64(), 10), true
+	case "int128", "uint128":
+		return formatInt128(r.Raw(16), type_name == "int128"), true
+	case "varint32":
+		return r.Varint32(), true
+	case "varuint32":
+		return r.Varuint32(), true
+	case "float32":
+		return r.Float32(), true
+	case "float64":
+		return r.Float64(), true
+	case "float128":
+		return "0x" + hex.EncodeToString(r.Raw(16)), true
+	case "time_point":
+		return time.Unix(0, r.Int64()*int64(time.Microsecond)).UTC().Format("2006-01-02T15:04:05.000"), true
+	case "time_point_sec":
+		return time.Unix(int64(r.Uint32()), 0).UTC().Format("2006-01-02T15:04:05"), true
+	case "block_timestamp_type":
+		ms := int64(r.Uint32())*500 + block_timestamp_epoch
+		return time.Unix(0, ms*int64(time.Millisecond)).UTC().Format("2006-01-02T15:04:05.000"), true
+	case "name":
+		return r.Name(), true
+	case "bytes":
+		return hex.EncodeToString(r.Bytes()), true
+	case "string":
+		return r.Text(), true
+	case "checksum160":
+		return hex.EncodeToString(r.Raw(20)), true
+	case "checksum256":
+		return r.Checksum256(), true
+	case "checksum512":
+		return hex.EncodeToString(r.Raw(64)), true
+	case "public_key":
+		return r.PublicKey(), true
+	case "signature":
+		return r.Signature(), true
+	case "symbol":
+		precision, code := r.Symbol()
+		return strconv.Itoa(int(precision)) + "," + code, true
+	case "symbol_code":
+		return strings.TrimRight(string(r.Raw(8)), "\x00"), true
+	case "asset":
+		return r.Asset(), true
+	case "extended_asset":
+		return map[string]interface{}{"quantity": r.Asset(), "contract": r.Name()}, true
+	}
+
+	return nil, false
+}
+
+// formatInt128 prints a little endian 128 bit integer in decimal
+func formatInt128(b []byte, signed bool) string {
+	if len(b) != 16 {
+		return ""
+	}
+
+	be := make([]byte, 16)
+	for i := range b {
+		be[15-i] = b[i]
+	}
+
+	n := new(big.Int).SetBytes(be)
+
+	if signed && be[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), 128))
+	}
+
+	return n.String()
+}
+
+// Action is an action as packed into a transaction
+type Action struct {
+	Account       string
+	Name          string
+	Authorization []PermissionLevel
+	Data          []byte
+}
+
+// UnpackTransaction reads the actions of a packed transaction,
+// such as the one a msig proposal holds
+func UnpackTransaction(data []byte) ([]Action, error) {
+	r := NewReader(data)
+
+	// expiration, ref_block_num, ref_block_prefix,
+	// max_net_usage_words, max_cpu_usage_ms, delay_sec
+	r.Uint32()
+	r.Uint16()
+	r.Uint32()
+	r.Varuint32()
+	r.Uint8()
+	r.Varuint32()
+
+	// context free actions come first
+	readActions(r)
+	actions := readActions(r)
+
+	return actions, r.Err()
+}
+
+func readActions(r *Reader) []Action {
+	actions := []Action{}
+
+	count := r.Varuint32()
+	for i := uint32(0); i < count && r.Err() == nil; i++ {
+		a := Action{Account: r.Name(), Name: r.Name()}
+
+		authorizations := r.Varuint32()
+		for j := uint32(0); j < authorizations && r.Err() == nil; j++ {
+			a.Authorization = append(a.Authorization, PermissionLevel{Actor: r.Name(), Permission: r.Name()})
+		}
+
+		a.Data = r.Bytes()
+		actions = append(actions, a)
+	}
+
+	return actions
+}
@@ -0,0 +1,429 @@
+package chain
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"golang.org/x/crypto/ripemd160"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var ErrShortRead = errors.New("binary: not enough data")
+
+// Reader decodes eosio's binary serialization.
+// The first error sticks, every read after it returns zero values.
+type Reader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func NewReader(data []byte) *Reader {
+	return &Reader{data: data}
+}
+
+func (r *Reader) Err() error {
+	return r.err
+}
+
+func (r *Reader) Remaining() int {
+	return len(r.data) - r.pos
+}
+
+func (r *Reader) Raw(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if n < 0 || r.pos+n > len(r.data) {
+		r.err = ErrShortRead
+		return nil
+	}
+
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *Reader) Uint8() uint8 {
+	b := r.Raw(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *Reader) Bool() bool {
+	return r.Uint8() != 0
+}
+
+func (r *Reader) Uint16() uint16 {
+	b := r.Raw(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (r *Reader) Uint32() uint32 {
+	b := r.Raw(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *Reader) Uint64() uint64 {
+	b := r.Raw(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (r *Reader) Int64() int64 {
+	return int64(r.Uint64())
+}
+
+func (r *Reader) Float32() float32 {
+	return math.Float32frombits(r.Uint32())
+}
+
+func (r *Reader) Float64() float64 {
+	return math.Float64frombits(r.Uint64())
+}
+
+func (r *Reader) Varuint32() uint32 {
+	var v uint32
+	var shift uint
+
+	for {
+		b := r.Uint8()
+		if r.err != nil {
+			return 0
+		}
+
+		v |= uint32(b&0x7f) << shift
+		shift += 7
+
+		if b&0x80 == 0 || shift >= 35 {
+			return v
+		}
+	}
+}
+
+func (r *Reader) Varint32() int32 {
+	v := r.Varuint32()
+	// zigzag encoded
+	return int32(v>>1) ^ -int32(v&1)
+}
+
+func (r *Reader) Bytes() []byte {
+	return r.Raw(int(r.Varuint32()))
+}
+
+func (r *Reader) Text() string {
+	return string(r.Bytes())
+}
+
+func (r *Reader) Checksum256() string {
+	return hex.EncodeToString(r.Raw(32))
+}
+
+// PublicKey reads a key variant and formats it the way nodeos prints it,
+// k1 keys in the legacy EOS form, the others with a PUB_ prefix
+func (r *Reader) PublicKey() string {
+	switch r.Varuint32() {
+	case 0:
+		key := r.Raw(33)
+		return "EOS" + base58Check(key, nil)
+	case 1:
+		return "PUB_R1_" + base58Check(r.Raw(33), []byte("R1"))
+	case 2:
+		// key, user presence and relying party id
+		key := append([]byte{}, r.Raw(33)...)
+		key = append(key, r.Uint8())
+		key = append(key, r.Raw(int(r.Varuint32()))...)
+		return "PUB_WA_" + base58Check(key, []byte("WA"))
+	}
+
+	if r.err == nil {
+		r.err = errors.New("binary: unsupported public key type")
+	}
+	return ""
+}
+
+func (r *Reader) Signature() string {
+	switch r.Varuint32() {
+	case 0:
+		return "SIG_K1_" + base58Check(r.Raw(65), []byte("K1"))
+	case 1:
+		return "SIG_R1_" + base58Check(r.Raw(65), []byte("R1"))
+	case 2:
+		// signature, authenticator data and client json
+		sig := append([]byte{}, r.Raw(65)...)
+		sig = append(sig, r.Bytes()...)
+		sig = append(sig, r.Bytes()...)
+		return "SIG_WA_" + base58Check(sig, []byte("WA"))
+	}
+
+	if r.err == nil {
+		r.err = errors.New("binary: unsupported signature type")
+	}
+	return ""
+}
+
+func (r *Reader) Name() string {
+	return NameToString(r.Uint64())
+}
+
+// Symbol is a precision byte followed by up to seven code characters
+func (r *Reader) Symbol() (uint8, string) {
+	b := r.Raw(8)
+	if b == nil {
+		return 0, ""
+	}
+	return b[0], strings.TrimRight(string(b[1:]), "\x00")
+}
+
+// Asset reads an amount and symbol and formats them as "10.0000 REM"
+func (r *Reader) Asset() string {
+	amount := r.Int64()
+	precision, code := r.Symbol()
+
+	return FormatAsset(amount, precision, code)
+}
+
+func FormatAsset(amount int64, precision uint8, code string) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	digits := strconv.FormatInt(amount, 10)
+
+	if precision > 0 {
+		for len(digits) <= int(precision) {
+			digits = "0" + digits
+		}
+		digits = digits[:len(digits)-int(precision)] + "." + digits[len(digits)-int(precision):]
+	}
+
+	return sign + digits + " " + code
+}
+
+// ParseAsset reads an asset such as "10.0000 REM" into
+// its amount in the smallest unit, precision and code
+func ParseAsset(asset string) (int64, uint8, string, error) {
+	parts := strings.Fields(asset)
+	if len(parts) != 2 {
+		return 0, 0, "", errors.New("asset: expected an amount and a symbol in " + asset)
+	}
+
+	precision := 0
+	if i := strings.Index(parts[0], "."); i >= 0 {
+		precision = len(parts[0]) - i - 1
+	}
+
+	amount, err := ParseAmount(parts[0], uint8(precision))
+
+	return amount, uint8(precision), parts[1], err
+}
+
+// ParseAmount reads a decimal such as "12.5" into units
+// of the given precision, extra decimals are an error
+func ParseAmount(text string, precision uint8) (int64, error) {
+	negative := strings.HasPrefix(text, "-")
+	text = strings.TrimPrefix(text, "-")
+
+	whole := text
+	fraction := ""
+
+	if i := strings.Index(text, "."); i >= 0 {
+		whole, fraction = text[:i], text[i+1:]
+	}
+
+	if len(fraction) > int(precision) {
+		return 0, errors.New("amount: " + text + " has more than " + strconv.Itoa(int(precision)) + " decimals")
+	}
+
+	for len(fraction) < int(precision) {
+		fraction += "0"
+	}
+
+	if len(whole) == 0 {
+		whole = "0"
+	}
+
+	amount, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if negative {
+		amount = -amount
+	}
+
+	return amount, nil
+}
+
+// base58Check appends the first four bytes of ripemd160(data + suffix)
+// to data and encodes the result in bitcoin's base58 alphabet
+func base58Check(data []byte, suffix []byte) string {
+	h := ripemd160.New()
+	h.Write(data)
+	h.Write(suffix)
+	checksum := h.Sum(nil)[:4]
+
+	return base58(append(append([]byte{}, data...), checksum...))
+}
+
+func base58(data []byte) string {
+	alphabet := "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+	n := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+	output := []byte{}
+
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		output = append(output, alphabet[mod.Int64()])
+	}
+
+	// leading zero bytes are kept as ones
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		output = append(output, alphabet[0])
+	}
+
+	for i, j := 0, len(output)-1; i < j; i, j = i+1, j-1 {
+		output[i], output[j] = output[j], output[i]
+	}
+
+	return string(output)
+}
+
+// NameToString decodes an eosio account or action name
+func NameToString(value uint64) string {
+	charmap := ".12345abcdefghijklmnopqrstuvwxyz"
+	str := make([]byte, 13)
+	tmp := value
+
+	for i := 0; i <= 12; i++ {
+		var c byte
+		if i == 0 {
+			c = charmap[tmp&0x0f]
+			tmp >>= 4
+		} else {
+			c = charmap[tmp&0x1f]
+			tmp >>= 5
+		}
+		str[12-i] = c
+	}
+
+	return strings.TrimRight(string(str), ".")
+}
+
+// StringToName encodes an eosio account or action name
+func StringToName(name string) uint64 {
+	symbol := func(c byte) uint64 {
+		switch {
+		case c >= 'a' && c <= 'z':
+			return uint64(c-'a') + 6
+		case c >= '1' && c <= '5':
+			return uint64(c-'1') + 1
+		}
+		return 0
+	}
+
+	var value uint64
+
+	for i := 0; i < 12 && i < len(name); i++ {
+		value |= (symbol(name[i]) & 0x1f) << uint(64-5*(i+1))
+	}
+
+	if len(name) > 12 {
+		value |= symbol(name[12]) & 0x0f
+	}
+
+	return value
+}
+
+// Writer encodes the few requests the bot sends in binary,
+// and the state history frames its tests replay
+type Writer struct {
+	data []byte
+}
+
+func (w *Writer) Bytes() []byte {
+	return w.data
+}
+
+func (w *Writer) Uint8(v uint8) {
+	w.data = append(w.data, v)
+}
+
+func (w *Writer) Bool(v bool) {
+	if v {
+		w.Uint8(1)
+	} else {
+		w.Uint8(0)
+	}
+}
+
+func (w *Writer) Uint16(v uint16) {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, v)
+	w.data = append(w.data, b...)
+}
+
+func (w *Writer) Uint32(v uint32) {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	w.data = append(w.data, b...)
+}
+
+func (w *Writer) Uint64(v uint64) {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	w.data = append(w.data, b...)
+}
+
+func (w *Writer) Int64(v int64) {
+	w.Uint64(uint64(v))
+}
+
+func (w *Writer) Raw(b []byte) {
+	w.data = append(w.data, b...)
+}
+
+// Blob writes b with its length first, as Reader.Bytes reads it
+func (w *Writer) Blob(b []byte) {
+	w.Varuint32(uint32(len(b)))
+	w.Raw(b)
+}
+
+func (w *Writer) Name(name string) {
+	w.Uint64(StringToName(name))
+}
+
+func (w *Writer) Varuint32(v uint32) {
+	for {
+		b := uint8(v & 0x7f)
+		v >>= 7
+
+		if v == 0 {
+			w.Uint8(b)
+			return
+		}
+
+		w.Uint8(b | 0x80)
+	}
+}
@@ -0,0 +1,208 @@
+package chain
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// how often expired entries are dropped from the cache
+const cache_sweep_interval = time.Minute
+
+// Cache keeps chain responses for a TTL per resource so the checks
+// that run every tick share one download instead of each fetching
+// the same table again. Resources are named after the call, tables
+// as "get_table_rows/<table>"; calls without a TTL pass through.
+// Concurrent requests for the same key wait for a single call.
+type Cache struct {
+	client Client
+	store  *cacheStore
+	// fresh views keep their own entries
+	prefix string
+}
+
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+type cacheStore struct {
+	mu       sync.Mutex
+	ttls     map[string]time.Duration
+	entries  map[string]cacheEntry
+	inflight map[string]*cacheCall
+	stats    map[string]*CacheStats
+	swept    time.Time
+}
+
+type cacheEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+type cacheCall struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func NewCache(c Client, ttls map[string]time.Duration) *Cache {
+	return &Cache{
+		client: c,
+		store: &cacheStore{
+			ttls:     ttls,
+			entries:  make(map[string]cacheEntry),
+			inflight: make(map[string]*cacheCall),
+			stats:    make(map[string]*CacheStats),
+		},
+	}
+}
+
+// Fresh caches the wrapped client's fresh view separately,
+// rows read from a lagging node never answer a fresh call
+func (c *Cache) Fresh() Client {
+	return &Cache{client: c.client.Fresh(), store: c.store, prefix: "fresh "}
+}
+
+// Stats returns a copy of the hit and miss counts per resource
+func (c *Cache) Stats() map[string]CacheStats {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	output := make(map[string]CacheStats)
+	for resource, s := range c.store.stats {
+		output[resource] = *s
+	}
+
+	return output
+}
+
+func (c *Cache) GetActions(q ActionQuery, out interface{}) error {
+	return c.client.GetActions(q, out)
+}
+
+func (c *Cache) GetTableRows(q TableQuery, out interface{}) error {
+	return c.cached("get_table_rows/"+q.Table, q, out, func(out interface{}) error {
+		return c.client.GetTableRows(q, out)
+	})
+}
+
+func (c *Cache) GetScheduledTransactions(lower_bound time.Time, limit int, out interface{}) error {
+	return c.client.GetScheduledTransactions(lower_bound, limit, out)
+}
+
+func (c *Cache) GetAccount(name string, out interface{}) error {
+	return c.cached("get_account", name, out, func(out interface{}) error {
+		return c.client.GetAccount(name, out)
+	})
+}
+
+func (c *Cache) GetAbi(account string, out interface{}) error {
+	return c.cached("get_abi", account, out, func(out interface{}) error {
+		return c.client.GetAbi(account, out)
+	})
+}
+
+func (c *Cache) GetCurrencyBalance(code string, account string, symbol string, out interface{}) error {
+	request := []string{code, account, symbol}
+
+	return c.cached("get_currency_balance", request, out, func(out interface{}) error {
+		return c.client.GetCurrencyBalance(code, account, symbol, out)
+	})
+}
+
+func (c *Cache) GetInfo(out interface{}) error {
+	return c.client.GetInfo(out)
+}
+
+func (c *Cache) GetBlock(num uint64, out interface{}) error {
+	return c.client.GetBlock(num, out)
+}
+
+func (c *Cache) GetTransaction(id string, out interface{}) error {
+	return c.client.GetTransaction(id, out)
+}
+
+// cached answers from the store while the entry for the request is
+// younger than the resource's TTL, otherwise it joins or starts the call
+func (c *Cache) cached(resource string, request interface{}, out interface{}, call func(out interface{}) error) error {
+	s := c.store
+
+	ttl := s.ttls[resource]
+	if ttl <= 0 {
+		return call(out)
+	}
+
+	key, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	k := c.prefix + resource + " " + string(key)
+	now := time.Now()
+
+	s.mu.Lock()
+	stats, ok := s.stats[resource]
+	if !ok {
+		stats = &CacheStats{}
+		s.stats[resource] = stats
+	}
+
+	if e, ok := s.entries[k]; ok && now.Before(e.expires) {
+		stats.Hits++
+		s.mu.Unlock()
+		return json.Unmarshal(e.data, out)
+	}
+
+	// someone is already fetching it
+	if pending, ok := s.inflight[k]; ok {
+		stats.Hits++
+		s.mu.Unlock()
+
+		<-pending.done
+		if pending.err != nil {
+			return pending.err
+		}
+		return json.Unmarshal(pending.data, out)
+	}
+
+	stats.Misses++
+	pending := &cacheCall{done: make(chan struct{})}
+	s.inflight[k] = pending
+	s.mu.Unlock()
+
+	var raw json.RawMessage
+	pending.err = call(&raw)
+	pending.data = raw
+
+	s.mu.Lock()
+	delete(s.inflight, k)
+	// errors are not kept, the next tick tries again
+	if pending.err == nil {
+		s.entries[k] = cacheEntry{data: pending.data, expires: time.Now().Add(ttl)}
+	}
+	s.sweep(now)
+	s.mu.Unlock()
+
+	close(pending.done)
+
+	if pending.err != nil {
+		return pending.err
+	}
+
+	return json.Unmarshal(pending.data, out)
+}
+
+// sweep drops expired entries, the caller holds mu
+func (s *cacheStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < cache_sweep_interval {
+		return
+	}
+	s.swept = now
+
+	for k, e := range s.entries {
+		if now.After(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+}
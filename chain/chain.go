@@ -0,0 +1,274 @@
+package chain
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/joho/godotenv"
+	"github.com/parnurzeal/gorequest"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var config map[string]string
+
+const (
+	api_url         = "CHAIN_API_URL"
+	v1_endpoints    = "CHAIN_V1_ENDPOINTS"
+	v2_endpoints    = "CHAIN_V2_ENDPOINTS"
+	max_lag         = "CHAIN_MAX_LAG"
+	stream_url      = "CHAIN_STREAM_URL"
+	ship_url        = "CHAIN_SHIP_URL"
+	registry        = "CHAIN_REGISTRY"
+	rank_margin     = "CHAIN_RANK_MARGIN"
+	default_api_url = "https://rem.eon.llc"
+	default_max_lag = "15"
+	default_margin  = "5"
+)
+
+// Client is every chain and history call the bot makes.
+// Each method decodes the node's JSON response into out.
+type Client interface {
+	GetActions(q ActionQuery, out interface{}) error
+	GetTableRows(q TableQuery, out interface{}) error
+	GetScheduledTransactions(lower_bound time.Time, limit int, out interface{}) error
+	GetAccount(name string, out interface{}) error
+	GetAbi(account string, out interface{}) error
+	// GetCurrencyBalance lists an account's balances on a token contract,
+	// only the one in symbol when it is set
+	GetCurrencyBalance(code string, account string, symbol string, out interface{}) error
+	GetInfo(out interface{}) error
+	GetBlock(num uint64, out interface{}) error
+	GetTransaction(id string, out interface{}) error
+	// Fresh returns a Client that only answers from nodes
+	// which are in sync with the chain head
+	Fresh() Client
+}
+
+// ActionQuery mirrors the Hyperion v2 get_actions filters we use.
+// Names, Accounts (actors) and Contracts are comma separated lists,
+// an empty one does not filter.
+type ActionQuery struct {
+	After     time.Time
+	Names     string
+	Accounts  string
+	Contracts string
+	Limit     int
+	Skip      int
+}
+
+// TableQuery is the body of a v1 get_table_rows request.
+type TableQuery struct {
+	Code       string `json:"code"`
+	Scope      string `json:"scope"`
+	Table      string `json:"table"`
+	LowerBound string `json:"lower_bound,omitempty"`
+	UpperBound string `json:"upper_bound,omitempty"`
+	Limit      int    `json:"limit"`
+	Reverse    bool   `json:"reverse,omitempty"`
+	JSON       bool   `json:"json"`
+}
+
+// default_lag and default_rank_margin apply to
+// chains that set no max_lag or rank_margin of their own
+var default_lag int
+var default_rank_margin float64
+
+func init() {
+	config = chainConfig()
+
+	var err error
+
+	default_lag, err = strconv.Atoi(config[max_lag])
+	if err != nil {
+		log.Print(err)
+	}
+
+	default_rank_margin, err = strconv.ParseFloat(config[rank_margin], 64)
+	if err != nil {
+		log.Print(err)
+	}
+
+	chains = loadRegistry(config[registry])
+}
+
+// HTTPClient talks to a nodeos v1 api and a Hyperion v2 api on the same host.
+type HTTPClient struct {
+	URL string
+}
+
+func NewHTTPClient(url string) *HTTPClient {
+	return &HTTPClient{URL: strings.TrimRight(url, "/")}
+}
+
+func (c *HTTPClient) GetActions(q ActionQuery, out interface{}) error {
+	// convert timestamp to ISO8601 for Hyperion
+	after := q.After.Format("2006-01-02T15:04:05")
+	filters := ""
+
+	// filters are optional
+	if len(q.Accounts) > 0 {
+		filters += "act.authorization.actor=" + q.Accounts + "&"
+	}
+
+	if len(q.Contracts) > 0 {
+		filters += "act.account=" + q.Contracts + "&"
+	}
+
+	if len(q.Names) > 0 {
+		filters += "act.name=" + q.Names + "&"
+	}
+
+	path := "/v2/history/get_actions?action_ordinal=1&" + filters + "limit=" + strconv.Itoa(q.Limit) + "&skip=" + strconv.Itoa(q.Skip) + "&sort=asc&after=" + after
+
+	return c.get(path, out)
+}
+
+func (c *HTTPClient) GetTableRows(q TableQuery, out interface{}) error {
+	q.JSON = true
+
+	return c.post("/v1/chain/get_table_rows", q, out)
+}
+
+func (c *HTTPClient) GetScheduledTransactions(lower_bound time.Time, limit int, out interface{}) error {
+	// convert timestamp to ISO8601 for nodeos
+	data := map[string]interface{}{
+		"json":        true,
+		"limit":       limit,
+		"lower_bound": lower_bound.Format("2006-01-02T15:04:05"),
+	}
+
+	return c.post("/v1/chain/get_scheduled_transactions", data, out)
+}
+
+func (c *HTTPClient) GetAccount(name string, out interface{}) error {
+	data := map[string]string{"account_name": name}
+
+	return c.post("/v1/chain/get_account", data, out)
+}
+
+func (c *HTTPClient) GetAbi(account string, out interface{}) error {
+	data := map[string]string{"account_name": account}
+
+	return c.post("/v1/chain/get_abi", data, out)
+}
+
+func (c *HTTPClient) GetCurrencyBalance(code string, account string, symbol string, out interface{}) error {
+	data := map[string]string{"code": code, "account": account}
+
+	if len(symbol) > 0 {
+		data["symbol"] = symbol
+	}
+
+	return c.post("/v1/chain/get_currency_balance", data, out)
+}
+
+func (c *HTTPClient) GetInfo(out interface{}) error {
+	return c.get("/v1/chain/get_info", out)
+}
+
+// GetHealth reads Hyperion's health report
+func (c *HTTPClient) GetHealth(out interface{}) error {
+	return c.get("/v2/health", out)
+}
+
+func (c *HTTPClient) GetBlock(num uint64, out interface{}) error {
+	data := map[string]string{"block_num_or_id": strconv.FormatUint(num, 10)}
+
+	return c.post("/v1/chain/get_block", data, out)
+}
+
+func (c *HTTPClient) GetTransaction(id string, out interface{}) error {
+	return c.get("/v2/history/get_transaction?id="+id, out)
+}
+
+// a single node has nothing to fail over to
+func (c *HTTPClient) Fresh() Client {
+	return c
+}
+
+func (c *HTTPClient) get(path string, out interface{}) error {
+	request := gorequest.New()
+	_, body, errs := request.Get(c.URL + path).End()
+
+	if errs != nil {
+		return errs[0]
+	}
+
+	return decode(body, out)
+}
+
+func (c *HTTPClient) post(path string, data interface{}, out interface{}) error {
+	request := gorequest.New()
+	_, body, errs := request.Post(c.URL + path).Send(data).End()
+
+	if errs != nil {
+		return errs[0]
+	}
+
+	return decode(body, out)
+}
+
+// nodeos answers errors with a json body and a 500 status,
+// callers such as account lookups rely on reading that body
+func decode(body string, out interface{}) error {
+	if len(body) == 0 {
+		return errors.New("empty response from chain api")
+	}
+
+	return json.Unmarshal([]byte(body), out)
+}
+
+func chainConfig() map[string]string {
+	err := godotenv.Load("/root/rem-alert-api/.env")
+	if err != nil {
+		log.Print("Error loading .env file")
+	}
+
+	conf := make(map[string]string)
+
+	conf[api_url] = os.Getenv(api_url)
+	conf[v1_endpoints] = os.Getenv(v1_endpoints)
+	conf[v2_endpoints] = os.Getenv(v2_endpoints)
+	conf[max_lag] = os.Getenv(max_lag)
+	conf[stream_url] = os.Getenv(stream_url)
+	conf[ship_url] = os.Getenv(ship_url)
+	conf[registry] = os.Getenv(registry)
+	conf[rank_margin] = os.Getenv(rank_margin)
+
+	if len(conf[api_url]) == 0 {
+		conf[api_url] = default_api_url
+	}
+
+	// a single api url serves both apis unless lists are given
+	if len(conf[v1_endpoints]) == 0 {
+		conf[v1_endpoints] = conf[api_url]
+	}
+
+	if len(conf[v2_endpoints]) == 0 {
+		conf[v2_endpoints] = conf[api_url]
+	}
+
+	if len(conf[max_lag]) == 0 {
+		conf[max_lag] = default_max_lag
+	}
+
+	if len(conf[rank_margin]) == 0 {
+		conf[rank_margin] = default_margin
+	}
+
+	return conf
+}
+
+func splitEndpoints(list string) []string {
+	output := []string{}
+	for _, l := range strings.Split(list, ",") {
+		l = strings.TrimSpace(l)
+		if len(l) > 0 {
+			output = append(output, l)
+		}
+	}
+	return output
+}
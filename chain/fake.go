@@ -0,0 +1,361 @@
+package chain
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fake is an in-memory Client, it lets watchman run without a network.
+// Rows and actions are stored as any json-encodable value
+// and are returned in the same shape nodeos and Hyperion use.
+type Fake struct {
+	mu        sync.Mutex
+	actions   []interface{}
+	tables    map[string][]interface{}
+	scheduled []interface{}
+	accounts  map[string]interface{}
+	abis      map[string]interface{}
+	balances  map[string][]string
+	blocks    map[uint64]interface{}
+	txs       map[string]interface{}
+	head      uint64
+	lib       uint64
+}
+
+type fakeAction struct {
+	Timestamp string `json:"@timestamp"`
+	Act       struct {
+		Account       string `json:"account"`
+		Name          string `json:"name"`
+		Authorization []struct {
+			Actor string `json:"actor"`
+		} `json:"authorization"`
+	} `json:"act"`
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		tables:   make(map[string][]interface{}),
+		accounts: make(map[string]interface{}),
+		abis:     make(map[string]interface{}),
+		balances: make(map[string][]string),
+		blocks:   make(map[uint64]interface{}),
+		txs:      make(map[string]interface{}),
+	}
+}
+
+func (f *Fake) AddActions(actions ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.actions = append(f.actions, actions...)
+}
+
+func (f *Fake) SetTable(code string, scope string, table string, rows ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.tables[code+"/"+scope+"/"+table] = rows
+}
+
+func (f *Fake) AddScheduledTransactions(transactions ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.scheduled = append(f.scheduled, transactions...)
+}
+
+func (f *Fake) SetAccount(name string, account interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.accounts[name] = account
+}
+
+func (f *Fake) SetAbi(account string, abi interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.abis[account] = abi
+}
+
+// SetBalance sets an account's balances on a token contract, such as "10.0000 REM"
+func (f *Fake) SetBalance(code string, account string, balances ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.balances[code+"/"+account] = balances
+}
+
+// SetHead moves the head and last irreversible block get_info reports
+func (f *Fake) SetHead(head uint64, lib uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.head = head
+	f.lib = lib
+}
+
+func (f *Fake) SetBlock(num uint64, block interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.blocks[num] = block
+}
+
+func (f *Fake) SetTransaction(id string, transaction interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.txs[id] = transaction
+}
+
+func (f *Fake) GetActions(q ActionQuery, out interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	names := splitList(q.Names)
+	accounts := splitList(q.Accounts)
+	contracts := splitList(q.Contracts)
+	matched := []interface{}{}
+
+	for _, a := range f.actions {
+		fa := fakeAction{}
+		if err := roundTrip(a, &fa); err != nil {
+			return err
+		}
+
+		ts, _ := time.Parse("2006-01-02T15:04:05.9", fa.Timestamp)
+		if ts.Before(q.After) {
+			continue
+		}
+
+		if len(names) > 0 && !names[fa.Act.Name] {
+			continue
+		}
+
+		if len(contracts) > 0 && !contracts[fa.Act.Account] {
+			continue
+		}
+
+		if len(accounts) > 0 {
+			found := false
+			for _, auth := range fa.Act.Authorization {
+				found = found || accounts[auth.Actor]
+			}
+			if !found {
+				continue
+			}
+		}
+
+		matched = append(matched, a)
+	}
+
+	total := len(matched)
+	if q.Skip >= len(matched) {
+		matched = matched[:0]
+	} else {
+		matched = matched[q.Skip:]
+	}
+
+	if q.Limit > 0 && len(matched) > q.Limit {
+		matched = matched[:q.Limit]
+	}
+
+	response := map[string]interface{}{
+		"actions": matched,
+		"total":   map[string]int{"value": total},
+	}
+
+	return roundTrip(response, out)
+}
+
+// GetTableRows keys the fake's rows by their position,
+// bounds and next_key are row indexes
+func (f *Fake) GetTableRows(q TableQuery, out interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	all := f.tables[q.Code+"/"+q.Scope+"/"+q.Table]
+
+	lower := 0
+	upper := len(all) - 1
+
+	if len(q.LowerBound) > 0 {
+		lower, _ = strconv.Atoi(q.LowerBound)
+	}
+
+	if len(q.UpperBound) > 0 {
+		bound, _ := strconv.Atoi(q.UpperBound)
+		if bound < upper {
+			upper = bound
+		}
+	}
+
+	keys := []int{}
+
+	if q.Reverse {
+		for i := upper; i >= lower; i-- {
+			keys = append(keys, i)
+		}
+	} else {
+		for i := lower; i <= upper; i++ {
+			keys = append(keys, i)
+		}
+	}
+
+	more := false
+	next_key := ""
+
+	if q.Limit > 0 && len(keys) > q.Limit {
+		more = true
+		next_key = strconv.Itoa(keys[q.Limit])
+		keys = keys[:q.Limit]
+	}
+
+	rows := make([]interface{}, 0, len(keys))
+	for _, i := range keys {
+		rows = append(rows, all[i])
+	}
+
+	response := map[string]interface{}{
+		"rows":     rows,
+		"more":     more,
+		"next_key": next_key,
+	}
+
+	return roundTrip(response, out)
+}
+
+func (f *Fake) GetScheduledTransactions(lower_bound time.Time, limit int, out interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	transactions := f.scheduled
+	if limit > 0 && len(transactions) > limit {
+		transactions = transactions[:limit]
+	}
+
+	response := map[string]interface{}{
+		"transactions": transactions,
+	}
+
+	return roundTrip(response, out)
+}
+
+func (f *Fake) GetAccount(name string, out interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	account, ok := f.accounts[name]
+
+	// same shape nodeos answers with for an unknown account
+	if !ok {
+		account = map[string]interface{}{
+			"code":    500,
+			"message": "Internal Service Error",
+		}
+	}
+
+	return roundTrip(account, out)
+}
+
+func (f *Fake) GetCurrencyBalance(code string, account string, symbol string, out interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	balances := []string{}
+
+	for _, balance := range f.balances[code+"/"+account] {
+		if len(symbol) == 0 || strings.HasSuffix(balance, " "+symbol) {
+			balances = append(balances, balance)
+		}
+	}
+
+	return roundTrip(balances, out)
+}
+
+// GetAbi answers accounts without a contract like nodeos, with no abi
+func (f *Fake) GetAbi(account string, out interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	response := map[string]interface{}{
+		"account_name": account,
+	}
+
+	if abi, ok := f.abis[account]; ok {
+		response["abi"] = abi
+	}
+
+	return roundTrip(response, out)
+}
+
+func (f *Fake) GetInfo(out interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	response := map[string]interface{}{
+		"head_block_num":              f.head,
+		"last_irreversible_block_num": f.lib,
+		"head_block_time":             time.Now().UTC().Format("2006-01-02T15:04:05.000"),
+	}
+
+	return roundTrip(response, out)
+}
+
+func (f *Fake) GetBlock(num uint64, out interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	block, ok := f.blocks[num]
+	if !ok {
+		return errors.New("fake: unknown block " + strconv.FormatUint(num, 10))
+	}
+
+	return roundTrip(block, out)
+}
+
+func (f *Fake) GetTransaction(id string, out interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	transaction, ok := f.txs[id]
+
+	// hyperion answers an unknown id with an empty action list
+	if !ok {
+		transaction = map[string]interface{}{
+			"trx_id":  id,
+			"actions": []interface{}{},
+		}
+	}
+
+	return roundTrip(transaction, out)
+}
+
+func (f *Fake) Fresh() Client {
+	return f
+}
+
+func roundTrip(in interface{}, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, out)
+}
+
+func splitList(list string) map[string]bool {
+	output := make(map[string]bool)
+	for _, l := range strings.Split(list, ",") {
+		if len(l) > 0 {
+			output[l] = true
+		}
+	}
+	return output
+}
@@ -0,0 +1,311 @@
+package chain
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// weight of the newest sample in the latency average
+	latency_weight = 0.3
+	// how often a node's head block is looked up again
+	health_interval = time.Second * 10
+	// longest a failing node is benched for
+	max_backoff = time.Minute * 5
+	// a block is produced every half second
+	block_interval = time.Millisecond * 500
+)
+
+var ErrNoEndpoint = errors.New("no healthy chain api endpoint available")
+
+// Pool spreads calls over several v1 (nodeos) and v2 (Hyperion) endpoints.
+// Endpoints are ranked by latency and recent errors, a failing one
+// is benched with a growing backoff and the next one is tried instead.
+type Pool struct {
+	v1      []*endpoint
+	v2      []*endpoint
+	max_lag time.Duration
+	fresh   bool
+}
+
+type endpoint struct {
+	mu              sync.Mutex
+	http            *HTTPClient
+	hyperion        bool
+	latency         time.Duration
+	errors          int
+	benched_until   time.Time
+	head_block_time time.Time
+	checked         time.Time
+}
+
+type info struct {
+	HeadBlockNum  int    `json:"head_block_num"`
+	HeadBlockTime string `json:"head_block_time"`
+}
+
+// health is Hyperion's /v2/health, the nodeos service reports the head
+// and the elasticsearch service how far the index has come
+type health struct {
+	Health []struct {
+		Service     string `json:"service"`
+		Status      string `json:"status"`
+		ServiceData struct {
+			HeadBlockNum     int    `json:"head_block_num"`
+			HeadBlockTime    string `json:"head_block_time"`
+			LastIndexedBlock int    `json:"last_indexed_block"`
+		} `json:"service_data"`
+	} `json:"health"`
+}
+
+func NewPool(v1 []string, v2 []string, max_lag time.Duration) *Pool {
+	p := &Pool{max_lag: max_lag}
+
+	for _, url := range v1 {
+		p.v1 = append(p.v1, &endpoint{http: NewHTTPClient(url)})
+	}
+
+	for _, url := range v2 {
+		p.v2 = append(p.v2, &endpoint{http: NewHTTPClient(url), hyperion: true})
+	}
+
+	return p
+}
+
+// Fresh returns a view of the pool that refuses nodes whose head block
+// is older than max_lag, instead of falling back to them.
+func (p *Pool) Fresh() Client {
+	fresh := *p
+	fresh.fresh = true
+	return &fresh
+}
+
+func (p *Pool) GetActions(q ActionQuery, out interface{}) error {
+	return p.do(p.v2, func(c *HTTPClient) error {
+		return c.GetActions(q, out)
+	})
+}
+
+func (p *Pool) GetTableRows(q TableQuery, out interface{}) error {
+	return p.do(p.v1, func(c *HTTPClient) error {
+		return c.GetTableRows(q, out)
+	})
+}
+
+func (p *Pool) GetScheduledTransactions(lower_bound time.Time, limit int, out interface{}) error {
+	return p.do(p.v1, func(c *HTTPClient) error {
+		return c.GetScheduledTransactions(lower_bound, limit, out)
+	})
+}
+
+func (p *Pool) GetAccount(name string, out interface{}) error {
+	return p.do(p.v1, func(c *HTTPClient) error {
+		return c.GetAccount(name, out)
+	})
+}
+
+func (p *Pool) GetAbi(account string, out interface{}) error {
+	return p.do(p.v1, func(c *HTTPClient) error {
+		return c.GetAbi(account, out)
+	})
+}
+
+func (p *Pool) GetCurrencyBalance(code string, account string, symbol string, out interface{}) error {
+	return p.do(p.v1, func(c *HTTPClient) error {
+		return c.GetCurrencyBalance(code, account, symbol, out)
+	})
+}
+
+func (p *Pool) GetInfo(out interface{}) error {
+	return p.do(p.v1, func(c *HTTPClient) error {
+		return c.GetInfo(out)
+	})
+}
+
+func (p *Pool) GetBlock(num uint64, out interface{}) error {
+	return p.do(p.v1, func(c *HTTPClient) error {
+		return c.GetBlock(num, out)
+	})
+}
+
+func (p *Pool) GetTransaction(id string, out interface{}) error {
+	return p.do(p.v2, func(c *HTTPClient) error {
+		return c.GetTransaction(id, out)
+	})
+}
+
+// do runs call against the best endpoint first and fails over
+// down the ranking until one of them answers
+func (p *Pool) do(endpoints []*endpoint, call func(c *HTTPClient) error) error {
+	var err error = ErrNoEndpoint
+
+	for _, e := range p.rank(endpoints) {
+		start := time.Now()
+		err = call(e.http)
+		e.record(time.Since(start), err)
+
+		if err == nil {
+			return nil
+		}
+
+		log.Print(e.http.URL + ": " + err.Error())
+	}
+
+	return err
+}
+
+// rank orders usable endpoints from best to worst score,
+// lagging nodes go last, or are dropped entirely for fresh pools
+func (p *Pool) rank(endpoints []*endpoint) []*endpoint {
+	now := time.Now()
+	fresh := []*endpoint{}
+	lagging := []*endpoint{}
+
+	for _, e := range endpoints {
+		e.checkHealth()
+
+		e.mu.Lock()
+		benched := now.Before(e.benched_until)
+		is_fresh := now.Sub(e.head_block_time) <= p.max_lag
+		e.mu.Unlock()
+
+		if benched {
+			continue
+		}
+
+		if is_fresh {
+			fresh = append(fresh, e)
+		} else {
+			lagging = append(lagging, e)
+		}
+	}
+
+	sort.SliceStable(fresh, func(i, j int) bool { return fresh[i].score() < fresh[j].score() })
+	sort.SliceStable(lagging, func(i, j int) bool { return lagging[i].score() < lagging[j].score() })
+
+	if p.fresh {
+		return fresh
+	}
+
+	return append(fresh, lagging...)
+}
+
+func (e *endpoint) score() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return float64(e.latency) * float64(1+e.errors)
+}
+
+func (e *endpoint) record(latency time.Duration, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.latency == 0 {
+		e.latency = latency
+	} else {
+		e.latency = time.Duration(latency_weight*float64(latency) + (1-latency_weight)*float64(e.latency))
+	}
+
+	if err == nil {
+		e.errors = 0
+		e.benched_until = time.Time{}
+		return
+	}
+
+	e.errors++
+
+	backoff := time.Second << uint(e.errors)
+	if backoff > max_backoff || backoff <= 0 {
+		backoff = max_backoff
+	}
+
+	e.benched_until = time.Now().Add(backoff)
+}
+
+// checkHealth refreshes the node's head block time, or for Hyperion
+// the time its index has reached, at most once every health_interval
+func (e *endpoint) checkHealth() {
+	e.mu.Lock()
+	due := time.Since(e.checked) >= health_interval
+	if due {
+		e.checked = time.Now()
+	}
+	e.mu.Unlock()
+
+	if !due {
+		return
+	}
+
+	var head time.Time
+
+	start := time.Now()
+	err := e.headBlockTime(&head)
+	e.record(time.Since(start), err)
+
+	if err != nil {
+		log.Print(e.http.URL + ": " + err.Error())
+		return
+	}
+
+	e.mu.Lock()
+	e.head_block_time = head
+	e.mu.Unlock()
+}
+
+func (e *endpoint) headBlockTime(head *time.Time) error {
+	var err error
+
+	if !e.hyperion {
+		i := info{}
+
+		err = e.http.GetInfo(&i)
+		if err != nil {
+			return err
+		}
+
+		// nodeos reports UTC without a zone suffix
+		*head, err = time.Parse("2006-01-02T15:04:05.9", i.HeadBlockTime)
+		return err
+	}
+
+	h := health{}
+
+	err = e.http.GetHealth(&h)
+	if err != nil {
+		return err
+	}
+
+	head_block_num, indexed := 0, 0
+
+	for _, service := range h.Health {
+		if service.Status != "OK" {
+			return errors.New("health: " + service.Service + " is " + service.Status)
+		}
+
+		switch service.Service {
+		case "NodeosRPC":
+			head_block_num = service.ServiceData.HeadBlockNum
+			*head, err = time.Parse("2006-01-02T15:04:05.9", service.ServiceData.HeadBlockTime)
+			if err != nil {
+				return err
+			}
+		case "Elasticsearch":
+			indexed = service.ServiceData.LastIndexedBlock
+		}
+	}
+
+	if head.IsZero() || indexed == 0 {
+		return errors.New("health: no head or indexed block reported")
+	}
+
+	// the index is as old as the blocks it has yet to catch up on
+	if indexed < head_block_num {
+		*head = head.Add(-block_interval * time.Duration(head_block_num-indexed))
+	}
+
+	return nil
+}
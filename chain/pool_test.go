@@ -0,0 +1,79 @@
+package chain
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// serveHealth answers get_info with a current head and /v2/health
+// with an index behind the head by lag blocks
+func serveHealth(t *testing.T, lag int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		head := time.Now().UTC().Format("2006-01-02T15:04:05.000")
+
+		switch r.URL.Path {
+		case "/v1/chain/get_info":
+			json.NewEncoder(w).Encode(map[string]interface{}{"head_block_num": 5000, "head_block_time": head})
+		case "/v2/health":
+			json.NewEncoder(w).Encode(map[string]interface{}{"health": []map[string]interface{}{
+				{"service": "NodeosRPC", "status": "OK", "service_data": map[string]interface{}{"head_block_num": 5000, "head_block_time": head}},
+				{"service": "Elasticsearch", "status": "OK", "service_data": map[string]interface{}{"last_indexed_block": 5000 - lag}},
+			}})
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+// a node benched for failing is used again once its health check passes
+func TestPoolUnbenchesOnHealthyCheck(t *testing.T) {
+	server := serveHealth(t, 0)
+	defer server.Close()
+
+	p := NewPool([]string{server.URL}, nil, time.Minute)
+	e := p.v1[0]
+	e.checked = time.Now()
+
+	for i := 0; i < 4; i++ {
+		e.record(time.Millisecond, errors.New("timeout"))
+	}
+
+	if len(p.rank(p.v1)) != 0 {
+		t.Fatal("failing node not benched")
+	}
+
+	// the next health check is due
+	e.checked = time.Time{}
+
+	if len(p.rank(p.v1)) != 1 {
+		t.Fatal("node still benched after a healthy check")
+	}
+}
+
+// hyperion is as fresh as its index, not as its node
+func TestPoolChecksHyperionIndex(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		lag   int
+		fresh bool
+	}{
+		{"indexed", 2, true},
+		{"index behind", 1000, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server := serveHealth(t, test.lag)
+			defer server.Close()
+
+			p := NewPool(nil, []string{server.URL}, time.Minute)
+
+			if fresh := len(p.Fresh().(*Pool).rank(p.v2)) == 1; fresh != test.fresh {
+				t.Errorf("fresh is %t, want %t", fresh, test.fresh)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package chain
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Chain is one network the bot watches.
+// Key is the short name accounts are scoped by, ID the network's chain id.
+type Chain struct {
+	Key    string   `json:"key"`
+	ID     string   `json:"chain_id"`
+	Name   string   `json:"name"`
+	V1     []string `json:"v1"`
+	V2     []string `json:"v2"`
+	Stream string   `json:"stream"`
+	Ship   string   `json:"ship"`
+	// transaction ids are appended to Explorer
+	Explorer     string `json:"explorer"`
+	ExplorerName string `json:"explorer_name"`
+	// system contracts, oracle and swap are
+	// left empty on chains that do not run them
+	System string `json:"system"`
+	Token  string `json:"token"`
+	Oracle string `json:"oracle"`
+	Swap   string `json:"swap"`
+	Msig   string `json:"msig"`
+	// core token as precision and code, e.g. "4,REM",
+	// stakes are printed in raw units without it
+	Symbol string `json:"symbol"`
+	// least stake, in the token's smallest unit, to hold guardian status,
+	// 0 turns guardian reminders off
+	GuardianStake uint64 `json:"guardian_stake"`
+	MaxLag        int    `json:"max_lag"`
+	// percent of the vote weight at the top 21 cutoff
+	// a producer within is warned it is close to it
+	RankMargin float64 `json:"rank_margin"`
+	// seconds responses are cached per resource, on top of default_ttls
+	CacheTTL map[string]int `json:"cache_ttl"`
+
+	Client Client `json:"-"`
+	Cache  *Cache `json:"-"`
+}
+
+// default_ttls keep tables the alerts and reminders read for a while,
+// calls not listed here always go to the chain
+var default_ttls = map[string]time.Duration{
+	"get_table_rows/producers": time.Second * 30,
+	"get_table_rows/swaps":     time.Second * 30,
+	"get_table_rows/voters":    time.Minute * 5,
+	"get_account":              time.Second * 10,
+	"get_abi":                  time.Minute * 10,
+	"get_currency_balance":     time.Second * 10,
+}
+
+var chains []*Chain
+
+// Chains lists every registered chain, the default one first
+func Chains() []*Chain {
+	return chains
+}
+
+// Default is the chain unscoped accounts belong to
+func Default() *Chain {
+	return chains[0]
+}
+
+// Get finds a chain by its key, in any case as replies are lowercased
+func Get(key string) (*Chain, bool) {
+	for _, c := range chains {
+		if strings.EqualFold(c.Key, key) {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// Scope names an account on a chain, accounts
+// on the default chain keep their bare name
+func Scope(key string, name string) string {
+	if key == Default().Key {
+		return name
+	}
+	return name + "@" + key
+}
+
+// Unscope splits a stored account into its chain key and name
+func Unscope(account string) (string, string) {
+	i := strings.LastIndex(account, "@")
+	if i < 0 {
+		return Default().Key, account
+	}
+	return account[i+1:], account[:i]
+}
+
+// AccountsOn lists the bare names of the accounts that are on the given chain
+func AccountsOn(key string, accounts []string) []string {
+	output := []string{}
+	for _, account := range accounts {
+		account_key, name := Unscope(account)
+		if account_key == key {
+			output = append(output, name)
+		}
+	}
+	return output
+}
+
+// loadRegistry reads the chains from the json file at path,
+// without one REM mainnet is built from the endpoint settings
+func loadRegistry(path string) []*Chain {
+	list := []*Chain{}
+
+	if len(path) > 0 {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = json.Unmarshal(data, &list)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if len(list) == 0 {
+		list = append(list, &Chain{
+			Key:           "rem",
+			Name:          "REM",
+			V1:            splitEndpoints(config[v1_endpoints]),
+			V2:            splitEndpoints(config[v2_endpoints]),
+			Stream:        config[stream_url],
+			Ship:          config[ship_url],
+			Explorer:      "https://remchain.remme.io/transaction/",
+			ExplorerName:  "Remme Explorer",
+			System:        "rem",
+			Token:         "rem.token",
+			Oracle:        "rem.oracle",
+			Swap:          "rem.swap",
+			Symbol:        "4,REM",
+			GuardianStake: 2500000000,
+		})
+	}
+
+	for _, c := range list {
+		if c.MaxLag == 0 {
+			c.MaxLag = default_lag
+		}
+
+		if c.RankMargin == 0 {
+			c.RankMargin = default_rank_margin
+		}
+
+		if len(c.System) == 0 {
+			c.System = "eosio"
+		}
+
+		if len(c.ExplorerName) == 0 {
+			c.ExplorerName = c.Name + " Explorer"
+		}
+
+		if len(c.Token) == 0 {
+			c.Token = c.System + ".token"
+		}
+
+		if len(c.Msig) == 0 {
+			c.Msig = c.System + ".msig"
+		}
+
+		// stream from the first hyperion host, "off" disables streaming
+		if len(c.Stream) == 0 && len(c.V2) > 0 {
+			c.Stream = c.V2[0]
+		} else if c.Stream == "off" {
+			c.Stream = ""
+		}
+
+		ttls := make(map[string]time.Duration)
+		for resource, ttl := range default_ttls {
+			ttls[resource] = ttl
+		}
+		for resource, seconds := range c.CacheTTL {
+			ttls[resource] = time.Duration(seconds) * time.Second
+		}
+
+		c.Cache = NewCache(NewPool(c.V1, c.V2, time.Duration(c.MaxLag)*time.Second), ttls)
+		c.Client = c.Cache
+	}
+
+	return list
+}
+
+// FormatStake prints an amount of the core token's smallest unit
+func (c *Chain) FormatStake(amount int64) string {
+	i := strings.Index(c.Symbol, ",")
+	if i < 0 {
+		return strconv.FormatInt(amount, 10)
+	}
+
+	precision, err := strconv.Atoi(c.Symbol[:i])
+	if err != nil {
+		return strconv.FormatInt(amount, 10)
+	}
+
+	return FormatAsset(amount, uint8(precision), c.Symbol[i+1:])
+}
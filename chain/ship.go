@@ -0,0 +1,396 @@
+package chain
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"strconv"
+	"time"
+)
+
+// Ship is a connection to nodeos' state_history_plugin.
+// Blocks and their traces are requested in binary
+// and decoded without any indexer in between.
+type Ship struct {
+	conn *websocket.Conn
+}
+
+type ShipStatus struct {
+	Head             uint32
+	LastIrreversible uint32
+	TraceBegin       uint32
+	TraceEnd         uint32
+}
+
+type ShipBlock struct {
+	Num              uint32
+	ID               string
+	Head             uint32
+	LastIrreversible uint32
+	Timestamp        time.Time
+	Traces           []TransactionTrace
+}
+
+type TransactionTrace struct {
+	ID           string
+	Status       uint8
+	Scheduled    bool
+	ActionTraces []ActionTrace
+}
+
+type ActionTrace struct {
+	Receiver       string
+	Account        string
+	Name           string
+	Authorization  []PermissionLevel
+	Data           []byte
+	GlobalSequence uint64
+	HasReceipt     bool
+}
+
+type PermissionLevel struct {
+	Actor      string
+	Permission string
+}
+
+const (
+	// variant indexes of the state history protocol
+	ship_status_request = 0
+	ship_blocks_request = 1
+	ship_ack_request    = 2
+
+	ship_status_result = 0
+	ship_blocks_result = 1
+
+	// transaction_trace status of an applied transaction
+	TraceExecuted = 0
+
+	// block timestamps count half seconds since 2000-01-01
+	block_timestamp_epoch = 946684800000
+)
+
+// DialShip connects to a state history endpoint such as ws://127.0.0.1:8080
+func DialShip(url string) (*Ship, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// nodeos opens with its protocol abi as text,
+	// the layout below is fixed so it is not needed
+	_, _, err = conn.ReadMessage()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Ship{conn: conn}, nil
+}
+
+func (s *Ship) Close() error {
+	return s.conn.Close()
+}
+
+func (s *Ship) Status() (ShipStatus, error) {
+	status := ShipStatus{}
+
+	w := &Writer{}
+	w.Varuint32(ship_status_request)
+
+	err := s.conn.WriteMessage(websocket.BinaryMessage, w.Bytes())
+	if err != nil {
+		return status, err
+	}
+
+	_, frame, err := s.conn.ReadMessage()
+	if err != nil {
+		return status, err
+	}
+
+	r := NewReader(frame)
+
+	if r.Varuint32() != ship_status_result {
+		return status, errors.New("ship: expected a status result")
+	}
+
+	status.Head, _ = readBlockPosition(r)
+	status.LastIrreversible, _ = readBlockPosition(r)
+	status.TraceBegin = r.Uint32()
+	status.TraceEnd = r.Uint32()
+
+	return status, r.Err()
+}
+
+// RequestBlocks starts streaming blocks with traces from start onwards
+func (s *Ship) RequestBlocks(start uint32, max_in_flight uint32) error {
+	w := &Writer{}
+	w.Varuint32(ship_blocks_request)
+	w.Uint32(start)
+	w.Uint32(0xffffffff)
+	w.Uint32(max_in_flight)
+	// have_positions
+	w.Varuint32(0)
+	// irreversible_only, fetch_block, fetch_traces, fetch_deltas
+	w.Bool(false)
+	w.Bool(true)
+	w.Bool(true)
+	w.Bool(false)
+
+	return s.conn.WriteMessage(websocket.BinaryMessage, w.Bytes())
+}
+
+// ReadBlock returns the next block and acknowledges it
+func (s *Ship) ReadBlock() (ShipBlock, error) {
+	for {
+		_, frame, err := s.conn.ReadMessage()
+		if err != nil {
+			return ShipBlock{}, err
+		}
+
+		w := &Writer{}
+		w.Varuint32(ship_ack_request)
+		w.Uint32(1)
+
+		err = s.conn.WriteMessage(websocket.BinaryMessage, w.Bytes())
+		if err != nil {
+			return ShipBlock{}, err
+		}
+
+		b, ok, err := decodeBlocksResult(frame)
+		if err != nil {
+			return b, err
+		}
+
+		// results without this_block only move the head
+		if ok {
+			return b, nil
+		}
+	}
+}
+
+func decodeBlocksResult(frame []byte) (ShipBlock, bool, error) {
+	b := ShipBlock{}
+	r := NewReader(frame)
+
+	variant := r.Varuint32()
+	if variant != ship_blocks_result {
+		return b, false, errors.New("ship: unsupported result variant " + strconv.Itoa(int(variant)))
+	}
+
+	b.Head, _ = readBlockPosition(r)
+	b.LastIrreversible, _ = readBlockPosition(r)
+
+	if !r.Bool() {
+		return b, false, r.Err()
+	}
+
+	b.Num, b.ID = readBlockPosition(r)
+
+	// prev_block
+	if r.Bool() {
+		readBlockPosition(r)
+	}
+
+	if r.Bool() {
+		block := NewReader(r.Bytes())
+		slot := block.Uint32()
+		b.Timestamp = time.Unix(0, (int64(slot)*500+block_timestamp_epoch)*int64(time.Millisecond)).UTC()
+
+		if block.Err() != nil {
+			return b, false, block.Err()
+		}
+	}
+
+	if r.Bool() {
+		traces := NewReader(r.Bytes())
+		count := traces.Varuint32()
+
+		for i := uint32(0); i < count && traces.Err() == nil; i++ {
+			b.Traces = append(b.Traces, readTransactionTrace(traces))
+		}
+
+		if traces.Err() != nil {
+			return b, false, traces.Err()
+		}
+	}
+
+	return b, true, r.Err()
+}
+
+func readBlockPosition(r *Reader) (uint32, string) {
+	return r.Uint32(), r.Checksum256()
+}
+
+func readTransactionTrace(r *Reader) TransactionTrace {
+	t := TransactionTrace{}
+
+	if r.Varuint32() != 0 {
+		r.err = errors.New("ship: unsupported transaction_trace variant")
+		return t
+	}
+
+	t.ID = r.Checksum256()
+	t.Status = r.Uint8()
+	// cpu_usage_us, net_usage_words, elapsed, net_usage
+	r.Uint32()
+	r.Varuint32()
+	r.Int64()
+	r.Uint64()
+	t.Scheduled = r.Bool()
+
+	count := r.Varuint32()
+	for i := uint32(0); i < count && r.Err() == nil; i++ {
+		t.ActionTraces = append(t.ActionTraces, readActionTrace(r))
+	}
+
+	// account_ram_delta
+	if r.Bool() {
+		r.Uint64()
+		r.Int64()
+	}
+
+	// except
+	if r.Bool() {
+		r.Bytes()
+	}
+
+	// error_code
+	if r.Bool() {
+		r.Uint64()
+	}
+
+	// failed_dtrx_trace
+	if r.Bool() {
+		readTransactionTrace(r)
+	}
+
+	// partial
+	if r.Bool() {
+		skipPartialTransaction(r)
+	}
+
+	return t
+}
+
+func readActionTrace(r *Reader) ActionTrace {
+	a := ActionTrace{}
+
+	variant := r.Varuint32()
+	if variant > 1 {
+		r.err = errors.New("ship: unsupported action_trace variant")
+		return a
+	}
+
+	// action_ordinal, creator_action_ordinal
+	r.Varuint32()
+	r.Varuint32()
+
+	if r.Bool() {
+		a.HasReceipt = true
+
+		if r.Varuint32() != 0 {
+			r.err = errors.New("ship: unsupported action_receipt variant")
+			return a
+		}
+
+		// receiver, act_digest
+		r.Uint64()
+		r.Raw(32)
+		a.GlobalSequence = r.Uint64()
+		// recv_sequence
+		r.Uint64()
+
+		auth_sequences := r.Varuint32()
+		for i := uint32(0); i < auth_sequences && r.Err() == nil; i++ {
+			r.Uint64()
+			r.Uint64()
+		}
+
+		// code_sequence, abi_sequence
+		r.Varuint32()
+		r.Varuint32()
+	}
+
+	a.Receiver = r.Name()
+	a.Account = r.Name()
+	a.Name = r.Name()
+
+	authorizations := r.Varuint32()
+	for i := uint32(0); i < authorizations && r.Err() == nil; i++ {
+		a.Authorization = append(a.Authorization, PermissionLevel{Actor: r.Name(), Permission: r.Name()})
+	}
+
+	a.Data = r.Bytes()
+
+	// context_free, elapsed, console
+	r.Bool()
+	r.Int64()
+	r.Bytes()
+
+	ram_deltas := r.Varuint32()
+	for i := uint32(0); i < ram_deltas && r.Err() == nil; i++ {
+		r.Uint64()
+		r.Int64()
+	}
+
+	// except
+	if r.Bool() {
+		r.Bytes()
+	}
+
+	// error_code
+	if r.Bool() {
+		r.Uint64()
+	}
+
+	// return_value, added in action_trace_v1
+	if variant == 1 {
+		r.Bytes()
+	}
+
+	return a
+}
+
+func skipPartialTransaction(r *Reader) {
+	if r.Varuint32() != 0 {
+		r.err = errors.New("ship: unsupported partial_transaction variant")
+		return
+	}
+
+	// expiration, ref_block_num, ref_block_prefix,
+	// max_net_usage_words, max_cpu_usage_ms, delay_sec
+	r.Uint32()
+	r.Uint16()
+	r.Uint32()
+	r.Varuint32()
+	r.Uint8()
+	r.Varuint32()
+
+	extensions := r.Varuint32()
+	for i := uint32(0); i < extensions && r.Err() == nil; i++ {
+		r.Uint16()
+		r.Bytes()
+	}
+
+	signatures := r.Varuint32()
+	for i := uint32(0); i < signatures && r.Err() == nil; i++ {
+		skipSignature(r)
+	}
+
+	context_free_data := r.Varuint32()
+	for i := uint32(0); i < context_free_data && r.Err() == nil; i++ {
+		r.Bytes()
+	}
+}
+
+func skipSignature(r *Reader) {
+	switch r.Varuint32() {
+	case 0, 1: // k1, r1
+		r.Raw(65)
+	case 2: // webauthn
+		r.Raw(65)
+		r.Bytes()
+		r.Bytes()
+	default:
+		r.err = errors.New("ship: unsupported signature type")
+	}
+}
@@ -0,0 +1,323 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/ripemd160"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func checksum(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func transferData(from string, to string, amount int64, memo string) []byte {
+	w := &Writer{}
+	w.Name(from)
+	w.Name(to)
+	w.Int64(amount)
+	w.Raw([]byte{4, 'R', 'E', 'M', 0, 0, 0, 0})
+	w.Blob([]byte(memo))
+
+	return w.Bytes()
+}
+
+// readFrames reads recorded frames, hex one per line, # starts a comment
+func readFrames(t *testing.T, path string) [][]byte {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames := [][]byte{}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		frame, err := hex.DecodeString(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		frames = append(frames, frame)
+	}
+
+	return frames
+}
+
+func writeBlockPosition(w *Writer, num uint32, id byte) {
+	w.Uint32(num)
+	w.Raw(checksum(id))
+}
+
+// serveShip answers a status request and streams the frames once
+// blocks are requested, every frame has to be acknowledged
+func serveShip(t *testing.T, frames [][]byte, done chan<- int) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"version":"eosio::abi/1.1"}`))
+
+		acks := 0
+		defer func() { done <- acks }()
+
+		for {
+			_, request, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			r := NewReader(request)
+
+			switch r.Varuint32() {
+			case ship_status_request:
+				status := &Writer{}
+				status.Varuint32(ship_status_result)
+				writeBlockPosition(status, 130, 0x13)
+				writeBlockPosition(status, 110, 0x11)
+				status.Uint32(1)
+				status.Uint32(131)
+				conn.WriteMessage(websocket.BinaryMessage, status.Bytes())
+
+			case ship_blocks_request:
+				if start := r.Uint32(); start != 120 {
+					t.Errorf("blocks requested from %d, want 120", start)
+				}
+
+				for _, frame := range frames {
+					conn.WriteMessage(websocket.BinaryMessage, frame)
+				}
+
+			case ship_ack_request:
+				acks++
+				if acks == len(frames) {
+					return
+				}
+			}
+		}
+	}))
+}
+
+func TestShipReadsBlocks(t *testing.T) {
+	done := make(chan int, 1)
+
+	server := serveShip(t, readFrames(t, "testdata/ship_blocks.hex"), done)
+	defer server.Close()
+
+	s, err := DialShip(strings.Replace(server.URL, "http://", "ws://", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	status, err := s.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.Head != 130 || status.LastIrreversible != 110 || status.TraceEnd != 131 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	err = s.RequestBlocks(120, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the head only result is acknowledged and skipped
+	b, err := s.ReadBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if acks := <-done; acks != 2 {
+		t.Errorf("%d blocks acknowledged, want 2", acks)
+	}
+
+	if b.Num != 120 || b.ID != hex.EncodeToString(checksum(120)) || b.Head != 130 || b.LastIrreversible != 110 {
+		t.Errorf("unexpected block %d %s at head %d lib %d", b.Num, b.ID, b.Head, b.LastIrreversible)
+	}
+
+	if want := time.Date(2026, 10, 17, 10, 0, 5, 0, time.UTC); !b.Timestamp.Equal(want) {
+		t.Errorf("block time %s, want %s", b.Timestamp, want)
+	}
+
+	if len(b.Traces) != 2 || b.Traces[0].Status != TraceExecuted || b.Traces[1].Status != 1 {
+		t.Fatalf("unexpected traces %+v", b.Traces)
+	}
+
+	if b.Traces[0].ID != hex.EncodeToString(checksum(0xaa)) {
+		t.Errorf("transaction id %s", b.Traces[0].ID)
+	}
+
+	traces := b.Traces[0].ActionTraces
+	if len(traces) != 5 {
+		t.Fatalf("read %d action traces, want 5", len(traces))
+	}
+
+	want := []struct {
+		receiver string
+		actor    string
+		sequence uint64
+		data     []byte
+	}{
+		{"rem.token", "alice", 11, transferData("alice", "bob", 12345, "rent")},
+		{"alice", "alice", 12, transferData("alice", "bob", 12345, "rent")},
+		{"bob", "alice", 13, transferData("alice", "bob", 12345, "rent")},
+		{"rem.token", "bob", 14, transferData("bob", "carol", 100, "fee")},
+		{"carol", "bob", 15, transferData("bob", "carol", 100, "fee")},
+	}
+
+	for i, at := range traces {
+		if !at.HasReceipt || at.Receiver != want[i].receiver || at.Account != "rem.token" || at.Name != "transfer" || at.GlobalSequence != want[i].sequence {
+			t.Errorf("action trace %d: %+v", i, at)
+		}
+
+		if len(at.Authorization) != 1 || at.Authorization[0] != (PermissionLevel{Actor: want[i].actor, Permission: "active"}) {
+			t.Errorf("action trace %d authorization %+v", i, at.Authorization)
+		}
+
+		if !bytes.Equal(at.Data, want[i].data) {
+			t.Errorf("action trace %d data %x", i, at.Data)
+		}
+	}
+
+	// the inline transfer's data decodes back
+	r := NewReader(traces[3].Data)
+	if from, to, quantity, memo := r.Name(), r.Name(), r.Asset(), r.Text(); from != "bob" || to != "carol" || quantity != "0.0100 REM" || memo != "fee" || r.Err() != nil {
+		t.Errorf("inline transfer %s %s %s %s %v", from, to, quantity, memo, r.Err())
+	}
+}
+
+func TestStringToName(t *testing.T) {
+	for _, name := range []string{"eosio", "rem.token", "a", "zzzzzzzzzzzz", "producer1111", "12345abcdefgh"} {
+		if got := NameToString(StringToName(name)); got != name {
+			t.Errorf("%s encodes back to %s", name, got)
+		}
+	}
+
+	if StringToName("eosio") != 6138663577826885632 {
+		t.Errorf("eosio is %d", StringToName("eosio"))
+	}
+}
+
+func decodeBase58(t *testing.T, text string) []byte {
+	alphabet := "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	n := new(big.Int)
+
+	for _, c := range text {
+		i := strings.IndexRune(alphabet, c)
+		if i < 0 {
+			t.Fatalf("%s is not base58", text)
+		}
+		n.Mul(n, big.NewInt(58))
+		n.Add(n, big.NewInt(int64(i)))
+	}
+
+	output := n.Bytes()
+	for _, c := range text {
+		if c != '1' {
+			break
+		}
+		output = append([]byte{0}, output...)
+	}
+
+	return output
+}
+
+// checkBase58 decodes text after prefix and checks its payload
+// and the ripemd160 checksum of payload and suffix
+func checkBase58(t *testing.T, text string, prefix string, payload []byte, suffix string) {
+	if !strings.HasPrefix(text, prefix) {
+		t.Fatalf("%s lacks the %s prefix", text, prefix)
+	}
+
+	data := decodeBase58(t, strings.TrimPrefix(text, prefix))
+	if len(data) != len(payload)+4 || !bytes.Equal(data[:len(payload)], payload) {
+		t.Fatalf("%s holds %x, want %x", text, data, payload)
+	}
+
+	h := ripemd160.New()
+	h.Write(payload)
+	h.Write([]byte(suffix))
+
+	if !bytes.Equal(data[len(payload):], h.Sum(nil)[:4]) {
+		t.Errorf("%s has a bad checksum", text)
+	}
+}
+
+func TestPublicKeyVariants(t *testing.T) {
+	k1, _ := hex.DecodeString("02c0ded2bc1f1305fb0faac5e6c03ee3a1924234985427b6167ca569d13df435cf")
+	r1 := bytes.Repeat([]byte{3}, 33)
+
+	w := &Writer{}
+	w.Varuint32(0)
+	w.Raw(k1)
+	w.Varuint32(1)
+	w.Raw(r1)
+	w.Varuint32(2)
+	w.Raw(r1)
+	w.Uint8(1)
+	w.Blob([]byte("example.com"))
+	w.Varuint32(3)
+
+	r := NewReader(w.Bytes())
+
+	// the well known development key
+	if key := r.PublicKey(); key != "EOS6MRyAjQq8ud7hVNYcfnVPJqcVpscN5So8BhtHuGYqET5GDW5CV" {
+		t.Errorf("k1 key %s", key)
+	}
+
+	checkBase58(t, r.PublicKey(), "PUB_R1_", r1, "R1")
+
+	wa := append(append(append([]byte{}, r1...), 1), []byte("example.com")...)
+	checkBase58(t, r.PublicKey(), "PUB_WA_", wa, "WA")
+
+	if key := r.PublicKey(); key != "" || r.Err() == nil {
+		t.Errorf("unknown key type read as %s", key)
+	}
+}
+
+func TestSignatureVariants(t *testing.T) {
+	sig := bytes.Repeat([]byte{7}, 65)
+
+	w := &Writer{}
+	w.Varuint32(0)
+	w.Raw(sig)
+	w.Varuint32(1)
+	w.Raw(sig)
+	w.Varuint32(2)
+	w.Raw(sig)
+	w.Blob([]byte("authenticator"))
+	w.Blob([]byte("client"))
+	w.Varuint32(3)
+
+	r := NewReader(w.Bytes())
+
+	checkBase58(t, r.Signature(), "SIG_K1_", sig, "K1")
+	checkBase58(t, r.Signature(), "SIG_R1_", sig, "R1")
+
+	wa := append(append(append([]byte{}, sig...), []byte("authenticator")...), []byte("client")...)
+	checkBase58(t, r.Signature(), "SIG_WA_", wa, "WA")
+
+	if s := r.Signature(); s != "" || r.Err() == nil {
+		t.Errorf("unknown signature type read as %s", s)
+	}
+}
@@ -0,0 +1,215 @@
+package chain
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stream is a connection to Hyperion's socket.io stream api.
+// Only the parts of the engine.io v3 framing Hyperion uses are spoken:
+// open, ping/pong, events and acknowledgements.
+type Stream struct {
+	conn     *websocket.Conn
+	write_mu sync.Mutex
+	done     chan struct{}
+	next_ack int
+}
+
+// StreamRequest is the body of an action_stream_request.
+// StartFrom is a block number, 0 streams live only.
+// FilterOp is "and", the default, or "or" to match any filter.
+type StreamRequest struct {
+	Contract  string         `json:"contract"`
+	Action    string         `json:"action"`
+	Account   string         `json:"account"`
+	StartFrom uint64         `json:"start_from"`
+	ReadUntil uint64         `json:"read_until"`
+	Filters   []StreamFilter `json:"filters"`
+	FilterOp  string         `json:"filter_op,omitempty"`
+}
+
+type StreamFilter struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+// StreamReplayed is the type of the message Read returns when a request
+// is acknowledged, hyperion acknowledges once the request's history was
+// sent, what follows for that request is live
+const StreamReplayed = "replayed"
+
+// StreamMessage is a streamed action, Message holds the same
+// json document hyperion returns from get_actions.
+type StreamMessage struct {
+	Type    string          `json:"type"`
+	Mode    string          `json:"mode"`
+	Message json.RawMessage `json:"message"`
+}
+
+type streamOpen struct {
+	PingInterval int `json:"pingInterval"`
+}
+
+type streamAck struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+// DialStream opens the stream of a Hyperion host such as https://rem.eon.llc
+func DialStream(url string) (*Stream, error) {
+	url = strings.TrimRight(url, "/")
+	url = strings.Replace(url, "https://", "wss://", 1)
+	url = strings.Replace(url, "http://", "ws://", 1)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"/stream/?EIO=3&transport=websocket", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Stream{conn: conn, done: make(chan struct{})}
+
+	// the first frame is the engine.io open packet
+	_, frame, err := conn.ReadMessage()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if len(frame) == 0 || frame[0] != '0' {
+		conn.Close()
+		return nil, errors.New("stream: unexpected handshake " + string(frame))
+	}
+
+	open := streamOpen{}
+
+	err = json.Unmarshal(frame[1:], &open)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if open.PingInterval <= 0 {
+		open.PingInterval = 25000
+	}
+
+	go s.ping(time.Duration(open.PingInterval) * time.Millisecond)
+
+	return s, nil
+}
+
+// Subscribe requests an action stream, a rejection
+// surfaces as an error from Read
+func (s *Stream) Subscribe(r StreamRequest) error {
+	if r.Filters == nil {
+		r.Filters = []StreamFilter{}
+	}
+
+	payload, err := json.Marshal([]interface{}{"action_stream_request", r})
+	if err != nil {
+		return err
+	}
+
+	s.next_ack++
+
+	return s.write("42" + strconv.Itoa(s.next_ack) + string(payload))
+}
+
+// Read blocks until the next streamed action or acknowledgement arrives
+func (s *Stream) Read() (StreamMessage, error) {
+	for {
+		_, frame, err := s.conn.ReadMessage()
+		if err != nil {
+			return StreamMessage{}, err
+		}
+
+		packet := string(frame)
+
+		switch {
+		case packet == "3" || packet == "40":
+			// pong and namespace connect
+
+		case packet == "1" || strings.HasPrefix(packet, "41"):
+			return StreamMessage{}, errors.New("stream: closed by server")
+
+		case strings.HasPrefix(packet, "43"):
+			acks := []streamAck{}
+
+			err = json.Unmarshal([]byte(strings.TrimLeft(packet[2:], "0123456789")), &acks)
+			if err != nil {
+				return StreamMessage{}, err
+			}
+
+			if len(acks) > 0 && acks[0].Status != "OK" {
+				return StreamMessage{}, errors.New("stream: request rejected " + acks[0].Error)
+			}
+
+			return StreamMessage{Type: StreamReplayed}, nil
+
+		case strings.HasPrefix(packet, "42"):
+			event := []json.RawMessage{}
+
+			err = json.Unmarshal([]byte(strings.TrimLeft(packet[2:], "0123456789")), &event)
+			if err != nil {
+				return StreamMessage{}, err
+			}
+
+			var name string
+			if len(event) < 2 || json.Unmarshal(event[0], &name) != nil || name != "message" {
+				continue
+			}
+
+			m := StreamMessage{}
+
+			err = json.Unmarshal(event[1], &m)
+			if err != nil {
+				return StreamMessage{}, err
+			}
+
+			// hyperion sends the action document as a json string
+			var inner string
+			if json.Unmarshal(m.Message, &inner) == nil {
+				m.Message = json.RawMessage(inner)
+			}
+
+			return m, nil
+		}
+	}
+}
+
+func (s *Stream) Close() error {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+
+	return s.conn.Close()
+}
+
+func (s *Stream) ping(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if s.write("2") != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Stream) write(packet string) error {
+	s.write_mu.Lock()
+	defer s.write_mu.Unlock()
+
+	return s.conn.WriteMessage(websocket.TextMessage, []byte(packet))
+}
@@ -0,0 +1,95 @@
+package chain
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// hyperion sends a request's history, acknowledges it, then streams live
+func TestStreamReadsReplayThenAcknowledgement(t *testing.T) {
+	requests := make(chan string, 1)
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		conn.WriteMessage(websocket.TextMessage, []byte(`0{"sid":"1","pingInterval":25000}`))
+		conn.WriteMessage(websocket.TextMessage, []byte(`40`))
+
+		_, request, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		requests <- string(request)
+
+		for _, frame := range []string{
+			`42["message",{"type":"action","mode":"history","message":"{\"global_sequence\":5}"}]`,
+			`3`,
+			`431[{"status":"OK"}]`,
+			`42["message",{"type":"action","mode":"live","message":"{\"global_sequence\":9}"}]`,
+		} {
+			conn.WriteMessage(websocket.TextMessage, []byte(frame))
+		}
+
+		conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	s, err := DialStream(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	err = s.Subscribe(StreamRequest{
+		Contract: "*",
+		Action:   "*",
+		Filters:  []StreamFilter{StreamFilter{Field: "notified", Value: "alice"}},
+		FilterOp: "or",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := <-requests
+	if !strings.HasPrefix(request, `421["action_stream_request",`) || !strings.Contains(request, `"filter_op":"or"`) {
+		t.Errorf("unexpected request %s", request)
+	}
+
+	want := []string{"history", StreamReplayed, "live"}
+
+	for _, mode := range want {
+		m, err := s.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if mode == StreamReplayed {
+			if m.Type != StreamReplayed {
+				t.Fatalf("read %s, want the acknowledgement", m.Type)
+			}
+			continue
+		}
+
+		if m.Mode != mode {
+			t.Fatalf("read a %s message, want %s", m.Mode, mode)
+		}
+
+		doc := struct {
+			GlobalSequence int `json:"global_sequence"`
+		}{}
+
+		if err := json.Unmarshal(m.Message, &doc); err != nil || doc.GlobalSequence == 0 {
+			t.Errorf("message not unwrapped: %s", m.Message)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package chain
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+)
+
+// pages a single table read may take before giving up
+const table_max_pages = 1000
+
+var ErrNoNextKey = errors.New("get_table_rows: node reports more rows but no next_key")
+
+type tablePage struct {
+	Rows    []json.RawMessage `json:"rows"`
+	More    json.RawMessage   `json:"more"`
+	NextKey string            `json:"next_key"`
+}
+
+// ReadTable pages through get_table_rows with q.Limit rows per request,
+// following next_key until the table is exhausted or max_rows are read,
+// 0 reads the whole table. The rows are decoded into out the same way
+// a single get_table_rows response would be, under "rows".
+func ReadTable(c Client, q TableQuery, max_rows int, out interface{}) error {
+	rows := []json.RawMessage{}
+
+	for page := 1; ; page++ {
+		p := tablePage{}
+
+		err := c.GetTableRows(q, &p)
+		if err != nil {
+			return err
+		}
+
+		rows = append(rows, p.Rows...)
+
+		if max_rows > 0 && len(rows) >= max_rows {
+			rows = rows[:max_rows]
+			break
+		}
+
+		more, next_key := p.next()
+		if !more {
+			break
+		}
+
+		if len(next_key) == 0 {
+			return ErrNoNextKey
+		}
+
+		if page == table_max_pages {
+			log.Print("get_table_rows: " + q.Code + " " + q.Table + " stopped at " + strconv.Itoa(len(rows)) + " rows, page cap reached")
+			break
+		}
+
+		// reverse reads walk down from the upper bound
+		if q.Reverse {
+			q.UpperBound = next_key
+		} else {
+			q.LowerBound = next_key
+		}
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"rows": rows})
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, out)
+}
+
+// next reads where the following page starts, more is a bool on
+// current nodes and the next key itself on some older ones
+func (p tablePage) next() (bool, string) {
+	var more bool
+	if json.Unmarshal(p.More, &more) == nil {
+		return more, p.NextKey
+	}
+
+	var key string
+	if json.Unmarshal(p.More, &key) == nil && len(key) > 0 {
+		return true, key
+	}
+
+	return false, ""
+}
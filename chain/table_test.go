@@ -0,0 +1,178 @@
+package chain
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+type tableRow struct {
+	ID int `json:"id"`
+}
+
+// serveTable answers get_table_rows for a table of size rows keyed 0 up,
+// bounds and next_key are keys. A size below 0 is a table without end.
+// old_more answers more with the next key the way older nodes do.
+func serveTable(t *testing.T, size int, old_more bool, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chain/get_table_rows" {
+			t.Errorf("unexpected request %s", r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+
+		atomic.AddInt32(requests, 1)
+
+		q := TableQuery{}
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			t.Error(err)
+			return
+		}
+
+		lower, upper := 0, size-1
+		if size < 0 {
+			upper = int(^uint32(0) >> 1)
+		}
+
+		if len(q.LowerBound) > 0 {
+			lower, _ = strconv.Atoi(q.LowerBound)
+		}
+		if len(q.UpperBound) > 0 {
+			bound, _ := strconv.Atoi(q.UpperBound)
+			if bound < upper {
+				upper = bound
+			}
+		}
+
+		rows := []tableRow{}
+		next := -1
+
+		for i := 0; lower+i <= upper; i++ {
+			key := lower + i
+			if q.Reverse {
+				key = upper - i
+			}
+
+			if len(rows) == q.Limit {
+				next = key
+				break
+			}
+
+			rows = append(rows, tableRow{ID: key})
+		}
+
+		response := map[string]interface{}{"rows": rows, "more": next >= 0, "next_key": ""}
+
+		if next >= 0 {
+			response["next_key"] = strconv.Itoa(next)
+
+			if old_more {
+				response["more"] = strconv.Itoa(next)
+				delete(response, "next_key")
+			}
+		}
+
+		json.NewEncoder(w).Encode(response)
+	}))
+}
+
+func TestReadTablePages(t *testing.T) {
+	const size = 3200
+	const limit = 500
+
+	for _, test := range []struct {
+		name     string
+		reverse  bool
+		old_more bool
+	}{
+		{"forward", false, false},
+		{"reverse", true, false},
+		{"more as next key", false, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var requests int32
+
+			server := serveTable(t, size, test.old_more, &requests)
+			defer server.Close()
+
+			out := struct {
+				Rows []tableRow `json:"rows"`
+			}{}
+
+			q := TableQuery{Code: "rem", Scope: "rem", Table: "producers", Limit: limit, Reverse: test.reverse}
+
+			err := ReadTable(NewHTTPClient(server.URL), q, 0, &out)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(out.Rows) != size {
+				t.Fatalf("read %d rows, want %d", len(out.Rows), size)
+			}
+
+			seen := make(map[int]bool)
+			for _, row := range out.Rows {
+				if seen[row.ID] {
+					t.Fatalf("row %d read twice", row.ID)
+				}
+				seen[row.ID] = true
+			}
+
+			if pages := int(atomic.LoadInt32(&requests)); pages != (size+limit-1)/limit {
+				t.Errorf("read in %d pages, want %d", pages, (size+limit-1)/limit)
+			}
+		})
+	}
+}
+
+func TestReadTableMaxRows(t *testing.T) {
+	var requests int32
+
+	server := serveTable(t, 3200, false, &requests)
+	defer server.Close()
+
+	out := struct {
+		Rows []tableRow `json:"rows"`
+	}{}
+
+	err := ReadTable(NewHTTPClient(server.URL), TableQuery{Code: "rem", Scope: "rem", Table: "producers", Limit: 500}, 1200, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out.Rows) != 1200 || out.Rows[1199].ID != 1199 {
+		t.Errorf("read %d rows, want the first 1200", len(out.Rows))
+	}
+
+	if requests != 3 {
+		t.Errorf("read in %d pages, want 3", requests)
+	}
+}
+
+// a node that always reports more rows must not keep the read going forever
+func TestReadTablePageCap(t *testing.T) {
+	var requests int32
+
+	server := serveTable(t, -1, false, &requests)
+	defer server.Close()
+
+	out := struct {
+		Rows []tableRow `json:"rows"`
+	}{}
+
+	err := ReadTable(NewHTTPClient(server.URL), TableQuery{Code: "rem", Scope: "rem", Table: "producers", Limit: 2}, 0, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if requests != table_max_pages {
+		t.Errorf("read %d pages, want the cap of %d", requests, table_max_pages)
+	}
+
+	if len(out.Rows) != table_max_pages*2 {
+		t.Errorf("read %d rows, want %d", len(out.Rows), table_max_pages*2)
+	}
+}
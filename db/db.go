@@ -8,11 +8,15 @@ import (
 	"github.com/lib/pq"
 	"log"
 	"os"
+	"strings"
 )
 
 var db *sql.DB
 var config map[string]string
 
+// called by UpdateUserAccounts, see OnAccountsUpdate
+var accounts_hooks []func(telegram_id string, accounts []string)
+
 const (
 	db_host    = "DB_HOST"
 	db_port    = "DB_PORT"
@@ -20,6 +24,10 @@ const (
 	db_pass    = "DB_PASS"
 	db_name    = "DB_NAME"
 	table_name = "TABLE_NAME"
+	// created by migrations/001_cursors_pending_state.sql
+	cursor_table_name  = "CURSOR_TABLE_NAME"
+	pending_table_name = "PENDING_TABLE_NAME"
+	state_table_name   = "STATE_TABLE_NAME"
 )
 
 type User struct {
@@ -34,10 +42,81 @@ type User struct {
 	LastReminder string         `json:"last_reminder"`
 }
 
+// Cursor marks the last action processed on a chain,
+// either for the whole bot or for a single telegram user.
+type Cursor struct {
+	Chain     string
+	Owner     string
+	Sequence  uint64
+	BlockNum  uint64
+	Timestamp string
+}
+
+// Pending is a sent notification whose block
+// was still reversible when it went out.
+type Pending struct {
+	ID         int
+	Chain      string
+	TelegramID string
+	MessageID  string
+	BlockNum   uint64
+	TrxID      string
+	Text       string
+}
+
 type Settings struct {
 	Notification Notification `json:"notification"`
 	Alert        Alert        `json:"alert"`
 	Reminder     Reminder     `json:"reminder"`
+	Voters       Voters       `json:"voters"`
+	Rewards      Rewards      `json:"rewards"`
+	Contracts    []Contract   `json:"contracts"`
+	// chain picked for the account being added
+	Chain string `json:"chain"`
+	// which list is being edited, "" for accounts, "contract", "resources",
+	// "balances" or "reliability", and the contract chosen before its actions are
+	Flow            string `json:"flow"`
+	PendingContract string `json:"pending_contract"`
+	// resource thresholds by account, and the account being set up
+	Resources      map[string]Resources `json:"resources"`
+	PendingAccount string               `json:"pending_account"`
+	Balances       []Balance            `json:"balances"`
+	// percent of expected blocks a monitored producer
+	// has to produce over a day, 0 for no alerts
+	Reliability int    `json:"reliability"`
+	Swaps       []Swap `json:"swaps"`
+}
+
+// Swap follows swaps on a chain, the one with the swap table's Key
+// or every swap Account starts or receives
+type Swap struct {
+	Chain   string `json:"chain"`
+	Key     string `json:"key"`
+	Account string `json:"account"`
+}
+
+// Balance is the band an account's balance of a token should stay in,
+// Min and Max are decimal amounts and empty when not set
+type Balance struct {
+	Account  string `json:"account"`
+	Contract string `json:"contract"`
+	Symbol   string `json:"symbol"`
+	Min      string `json:"min"`
+	Max      string `json:"max"`
+}
+
+// Resources are usage thresholds in percent, 0 leaves a resource unwatched
+type Resources struct {
+	RAM int `json:"ram"`
+	CPU int `json:"cpu"`
+	NET int `json:"net"`
+}
+
+// Contract is a watched contract, stored scoped like accounts.
+// Every action executed on it is sent, or only the listed ones.
+type Contract struct {
+	Account string   `json:"account"`
+	Actions []string `json:"actions"`
 }
 
 type Notification struct {
@@ -56,7 +135,25 @@ type Reminder struct {
 	MessageID json.Number `json:"message_id, Number"`
 }
 
+// Voters is what producer owners hear about their voters, off when empty
+type Voters struct {
+	Setting   string      `json:"setting"`
+	MessageID json.Number `json:"message_id"`
+}
+
+// Rewards is when producer owners are reminded to claim, off when empty
+type Rewards struct {
+	Setting   string      `json:"setting"`
+	MessageID json.Number `json:"message_id"`
+}
+
 func init() {
+	// test binaries connect nothing, they pass their database to Use
+	if strings.HasSuffix(os.Args[0], ".test") {
+		config = make(map[string]string)
+		return
+	}
+
 	config = dbConfig()
 	var err error
 	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s "+
@@ -74,6 +171,11 @@ func init() {
 	}
 }
 
+// Use runs the package on an already opened database, such as a mock in tests
+func Use(conn *sql.DB) {
+	db = conn
+}
+
 func GetUser(telegram_id string) (User, error) {
 	u := User{}
 
@@ -157,6 +259,46 @@ func UpdateUserAccounts(telegram_id string, accounts []string) {
 	if err != nil {
 		panic(err)
 	}
+
+	for _, hook := range accounts_hooks {
+		hook(telegram_id, accounts)
+	}
+}
+
+// OnAccountsUpdate registers a function that is called
+// with a user's new accounts each time they are saved
+func OnAccountsUpdate(hook func(telegram_id string, accounts []string)) {
+	accounts_hooks = append(accounts_hooks, hook)
+}
+
+// GetUserAccounts maps every user that monitors accounts to their accounts
+func GetUserAccounts() (map[string][]string, error) {
+	output := make(map[string][]string)
+
+	query := `
+        SELECT telegram_id, accounts
+        FROM ` + config[table_name] + `
+        WHERE array_length(accounts, 1) > 0;`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return output, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var telegram_id string
+		var accounts pq.StringArray
+
+		err = rows.Scan(&telegram_id, &accounts)
+		if err != nil {
+			return output, err
+		}
+
+		output[telegram_id] = accounts
+	}
+
+	return output, rows.Err()
 }
 
 func UpdateSettings(telegram_id string, s Settings) {
@@ -209,6 +351,206 @@ func UpdateLastReminder(telegram_id string, timestamp string) {
 	}
 }
 
+// GetCursor returns an empty cursor when none was saved yet
+func GetCursor(chain string, owner string) (Cursor, error) {
+	c := Cursor{Chain: chain, Owner: owner}
+
+	query := `
+        SELECT sequence, block_num, timestamp
+        FROM ` + config[cursor_table_name] + `
+        WHERE chain = $1 AND owner = $2;`
+
+	row := db.QueryRow(query, chain, owner)
+
+	err := row.Scan(&c.Sequence, &c.BlockNum, &c.Timestamp)
+	if err == sql.ErrNoRows {
+		return c, nil
+	}
+
+	return c, err
+}
+
+func GetCursors(chain string) (map[string]Cursor, error) {
+	cursors := make(map[string]Cursor)
+
+	query := `
+        SELECT owner, sequence, block_num, timestamp
+        FROM ` + config[cursor_table_name] + `
+        WHERE chain = $1;`
+
+	rows, err := db.Query(query, chain)
+	if err != nil {
+		return cursors, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c := Cursor{Chain: chain}
+		err = rows.Scan(&c.Owner, &c.Sequence, &c.BlockNum, &c.Timestamp)
+		if err != nil {
+			return cursors, err
+		}
+
+		cursors[c.Owner] = c
+	}
+
+	return cursors, rows.Err()
+}
+
+func UpdateCursor(c Cursor) {
+	query := `
+        INSERT INTO ` + config[cursor_table_name] + ` (chain, owner, sequence, block_num, timestamp)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (chain, owner)
+        DO UPDATE SET sequence = $3, block_num = $4, timestamp = $5`
+
+	_, err := db.Exec(query, c.Chain, c.Owner, c.Sequence, c.BlockNum, c.Timestamp)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func InsertPending(p Pending) {
+	query := `
+        INSERT INTO ` + config[pending_table_name] + ` (chain, telegram_id, message_id, block_num, trx_id, text)
+        VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err := db.Exec(query, p.Chain, p.TelegramID, p.MessageID, p.BlockNum, p.TrxID, p.Text)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// GetPending lists notifications awaiting confirmation, oldest block first
+func GetPending(chain string) ([]Pending, error) {
+	pending := []Pending{}
+
+	query := `
+        SELECT id, chain, telegram_id, message_id, block_num, trx_id, text
+        FROM ` + config[pending_table_name] + `
+        WHERE chain = $1
+        ORDER BY block_num;`
+
+	rows, err := db.Query(query, chain)
+	if err != nil {
+		return pending, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p := Pending{}
+		err = rows.Scan(&p.ID, &p.Chain, &p.TelegramID, &p.MessageID, &p.BlockNum, &p.TrxID, &p.Text)
+		if err != nil {
+			return pending, err
+		}
+
+		pending = append(pending, p)
+	}
+
+	return pending, rows.Err()
+}
+
+func UpdatePendingBlock(id int, block_num uint64) {
+	query := `
+        UPDATE ` + config[pending_table_name] + `
+        SET block_num = $2
+        WHERE id = $1`
+
+	_, err := db.Exec(query, id, block_num)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func DeletePending(id int) {
+	query := `
+        DELETE FROM ` + config[pending_table_name] + `
+        WHERE id = $1`
+
+	_, err := db.Exec(query, id)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// GetState decodes the value saved under key into out,
+// false when nothing was saved yet
+func GetState(chain string, key string, out interface{}) (bool, error) {
+	var value []byte
+
+	query := `
+        SELECT value
+        FROM ` + config[state_table_name] + `
+        WHERE chain = $1 AND key = $2;`
+
+	err := db.QueryRow(query, chain, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, json.Unmarshal(value, out)
+}
+
+// GetStates returns every value whose key starts with prefix, by key
+func GetStates(chain string, prefix string) (map[string]json.RawMessage, error) {
+	states := make(map[string]json.RawMessage)
+
+	query := `
+        SELECT key, value
+        FROM ` + config[state_table_name] + `
+        WHERE chain = $1 AND left(key, length($2)) = $2;`
+
+	rows, err := db.Query(query, chain, prefix)
+	if err != nil {
+		return states, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var value []byte
+
+		err = rows.Scan(&key, &value)
+		if err != nil {
+			return states, err
+		}
+
+		states[key] = json.RawMessage(value)
+	}
+
+	return states, rows.Err()
+}
+
+// SetState saves value as json under key
+func SetState(chain string, key string, value interface{}) {
+	query := `
+        INSERT INTO ` + config[state_table_name] + ` (chain, key, value)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (chain, key)
+        DO UPDATE SET value = $3`
+
+	data, _ := json.Marshal(value)
+
+	_, err := db.Exec(query, chain, key, data)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func DeleteState(chain string, key string) {
+	query := `
+        DELETE FROM ` + config[state_table_name] + `
+        WHERE chain = $1 AND key = $2`
+
+	_, err := db.Exec(query, chain, key)
+	if err != nil {
+		panic(err)
+	}
+}
+
 func (s *Settings) Scan(src interface{}) error {
 	strValue, ok := src.([]uint8)
 
@@ -233,6 +575,9 @@ func dbConfig() map[string]string {
 	conf[db_pass] = os.Getenv(db_pass)
 	conf[db_name] = os.Getenv(db_name)
 	conf[table_name] = os.Getenv(table_name)
+	conf[cursor_table_name] = os.Getenv(cursor_table_name)
+	conf[pending_table_name] = os.Getenv(pending_table_name)
+	conf[state_table_name] = os.Getenv(state_table_name)
 
 	return conf
 }
@@ -0,0 +1,29 @@
+-- Tables for cursors, reversible notifications and check state.
+-- Rename them to match CURSOR_TABLE_NAME, PENDING_TABLE_NAME
+-- and STATE_TABLE_NAME if those are set to other names.
+
+CREATE TABLE IF NOT EXISTS cursors (
+    chain     text NOT NULL,
+    owner     text NOT NULL,
+    sequence  bigint NOT NULL DEFAULT 0,
+    block_num bigint NOT NULL DEFAULT 0,
+    timestamp text NOT NULL DEFAULT '',
+    PRIMARY KEY (chain, owner)
+);
+
+CREATE TABLE IF NOT EXISTS pending (
+    id          serial PRIMARY KEY,
+    chain       text NOT NULL,
+    telegram_id text NOT NULL,
+    message_id  text NOT NULL,
+    block_num   bigint NOT NULL,
+    trx_id      text NOT NULL,
+    text        text NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS state (
+    chain text NOT NULL,
+    key   text NOT NULL,
+    value jsonb NOT NULL,
+    PRIMARY KEY (chain, key)
+);
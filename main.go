@@ -16,11 +16,16 @@ func main() {
 }
 
 func startParser() {
-	go func() {
-		process := gocron.NewScheduler()
-		process.Every(1).Seconds().Do(watchman.Watch)
-		<-process.Start()
-	}()
+	// a scheduler per job, so a slow check never holds up another
+	for _, job := range watchman.Jobs() {
+		go func(job *watchman.Job) {
+			process := gocron.NewScheduler()
+			process.Every(uint64(job.Interval / time.Second)).Seconds().Do(job.Run)
+			<-process.Start()
+		}(job)
+	}
+
+	go watchman.Stream()
 }
 
 func startServer() {
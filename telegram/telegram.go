@@ -1,38 +1,61 @@
 package telegram
 
 import (
+	"../chain"
 	"../db"
 	_ "bytes"
 	"encoding/json"
+	"errors"
 	_ "fmt"
 	"github.com/joho/godotenv"
 	"github.com/parnurzeal/gorequest"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
 
 var config map[string]string
 
+// the bot api, the bot's key follows, see SetAPI
+var api_url = "https://api.telegram.org/bot"
+
+// set by OnProducerCommand
+var producer_report func(c *chain.Chain, name string) (string, bool)
+
 const (
-	api_key        = "API_KEY"
-	webhook_key    = "TELEGRAM_WEBHOOK_KEY"
-	start          = "/start"
-	main_menu      = "main menu"
-	add_account    = "add account"
-	remove_account = "remove account"
-	show_accounts  = "show accounts"
-	settings       = "settings"
-	notifications  = "account alerts"
-	alerts         = "producer alerts"
-	reminders      = "guardian alerts"
-	cancel         = "back"
+	api_key         = "API_KEY"
+	webhook_key     = "TELEGRAM_WEBHOOK_KEY"
+	start           = "/start"
+	main_menu       = "main menu"
+	add_account     = "add account"
+	remove_account  = "remove account"
+	add_contract    = "watch contract"
+	remove_contract = "unwatch contract"
+	all_actions     = "all actions"
+	show_accounts   = "show accounts"
+	settings        = "settings"
+	notifications   = "account alerts"
+	alerts          = "producer alerts"
+	reminders       = "guardian alerts"
+	resources       = "resource alerts"
+	resources_off   = "off"
+	balances        = "balance alerts"
+	voters          = "voter alerts"
+	rewards         = "reward reminders"
+	reliability     = "reliability alerts"
+	show_producer   = "/producer"
+	follow_swap     = "/swap"
+	unfollow_swap   = "/unswap"
+	show_balance    = "/balance"
+	cancel          = "back"
 
 	NotifyAll       = "Send me all notifications"
 	NotifyTransfers = "Notify only about token transfers"
 	NotifyChanges   = "Notify only about account changes"
+	NotifyStake     = "Notify only about stake & resources"
 	NotifyStop      = "Stop all notifications"
 
 	AlertAll      = "Alert when any producer fails"
@@ -43,6 +66,16 @@ const (
 	RemindWeekly  = "Remind me only to vote weekly"
 	RemindMonthly = "Remind me only to vote monthly"
 	RemindStop    = "Stop all reminders"
+
+	VotersAll     = "Voter changes and a daily digest"
+	VotersChanges = "Only voter changes"
+	VotersDigest  = "Only a daily voter digest"
+	VotersStop    = "Stop all voter alerts"
+
+	RewardsClaimable = "Remind me when rewards can be claimed"
+	RewardsThreeDays = "Remind me of rewards unclaimed for 3 days"
+	RewardsWeek      = "Remind me of rewards unclaimed for 7 days"
+	RewardsStop      = "Stop reward reminders"
 )
 
 type response struct {
@@ -103,6 +136,14 @@ var default_keyboard = [][]Button{
 			Text: remove_account,
 		},
 	},
+	[]Button{
+		Button{
+			Text: add_contract,
+		},
+		Button{
+			Text: remove_contract,
+		},
+	},
 	[]Button{
 		Button{
 			Text: show_accounts,
@@ -113,6 +154,14 @@ var default_keyboard = [][]Button{
 	},
 }
 
+// flows besides accounts, see db.Settings.Flow
+const (
+	contract_flow    = "contract"
+	resources_flow   = "resources"
+	balances_flow    = "balances"
+	reliability_flow = "reliability"
+)
+
 var cancel_keyboard = [][]Button{
 	[]Button{
 		Button{
@@ -125,6 +174,16 @@ func init() {
 	config = apiConfig()
 }
 
+// SetAPI points every bot api call at another host, such as a test server.
+func SetAPI(url string) {
+	api_url = strings.TrimRight(url, "/") + "/bot"
+}
+
+// SetClient points account lookups on the default chain at another chain api.
+func SetClient(c chain.Client) {
+	chain.Default().Client = c
+}
+
 func Webhook(w http.ResponseWriter, r *http.Request) {
 	// buf := new(bytes.Buffer)
 	// buf.ReadFrom(r.Body)
@@ -156,9 +215,15 @@ func Webhook(w http.ResponseWriter, r *http.Request) {
 
 			if strings.Contains(strings.ToLower(data.Callback.Data), "notif") {
 				user.Settings.Notification.Setting = data.Callback.Data
+			} else if strings.Contains(strings.ToLower(data.Callback.Data), "reward") {
+				user.Settings.Rewards.Setting = data.Callback.Data
+				setting_type = "rewards"
 			} else if strings.Contains(strings.ToLower(data.Callback.Data), "remind") {
 				user.Settings.Reminder.Setting = data.Callback.Data
 				setting_type = "reminder"
+			} else if strings.Contains(strings.ToLower(data.Callback.Data), "voter") {
+				user.Settings.Voters.Setting = data.Callback.Data
+				setting_type = "voters"
 			} else {
 				user.Settings.Alert.Setting = data.Callback.Data
 				setting_type = "alert"
@@ -179,12 +244,14 @@ func Webhook(w http.ResponseWriter, r *http.Request) {
 				notification := db.Notification{Setting: NotifyAll}
 				alert := db.Alert{Setting: AlertStop, Snooze: "1970-01-01T00:00:00.000"}
 				reminder := db.Reminder{Setting: RemindStop}
+				voters := db.Voters{Setting: VotersStop}
+				rewards := db.Rewards{Setting: RewardsStop}
 
 				user.TelegramID = chat_id
 				user.Accounts = []string{}
 				user.Editing = false
 				user.Adding = true
-				user.Settings = db.Settings{Notification: notification, Alert: alert, Reminder: reminder}
+				user.Settings = db.Settings{Notification: notification, Alert: alert, Reminder: reminder, Voters: voters, Rewards: rewards}
 				user.LastCheck = time.Now().Format(time.RFC3339)
 				user.LastAlert = user.LastCheck
 				user.LastReminder = user.LastCheck
@@ -230,6 +297,14 @@ func Webhook(w http.ResponseWriter, r *http.Request) {
 
 					removeAccount(user)
 
+				case add_contract:
+
+					addContract(user)
+
+				case remove_contract:
+
+					removeContract(user)
+
 				case settings:
 
 					openSettingsMenu(user)
@@ -246,9 +321,41 @@ func Webhook(w http.ResponseWriter, r *http.Request) {
 
 					openReminderSettings(user)
 
+				case voters:
+
+					openVoterSettings(user)
+
+				case rewards:
+
+					openRewardSettings(user)
+
+				case resources:
+
+					openResourceSettings(user)
+
+				case balances:
+
+					openBalanceSettings(user)
+
+				case show_balance:
+
+					showBalances(user)
+
+				case reliability:
+
+					openReliabilitySettings(user)
+
 				default:
 
-					unknownCommand(user)
+					if strings.HasPrefix(message, show_producer) {
+						showProducer(user, strings.TrimSpace(strings.TrimPrefix(message, show_producer)))
+					} else if strings.HasPrefix(message, follow_swap) {
+						followSwap(user, strings.TrimSpace(strings.TrimPrefix(message, follow_swap)))
+					} else if strings.HasPrefix(message, unfollow_swap) {
+						unfollowSwap(user, strings.TrimSpace(strings.TrimPrefix(message, unfollow_swap)))
+					} else {
+						unknownCommand(user)
+					}
 
 				}
 			}
@@ -279,13 +386,26 @@ func showAccounts(user db.User) {
 		text = "You're monitoring these accounts:"
 
 		for _, account := range user.Accounts {
-			text += `\n` + "*" + account + "*"
+			key, name := chain.Unscope(account)
+			text += `\n` + "*" + name + "*"
+
+			if c, ok := chain.Get(key); ok && c != chain.Default() {
+				text += " on " + c.Name
+			}
 		}
 
 	} else {
 		text = "You aren't monitoring any accounts."
 	}
 
+	if len(user.Settings.Contracts) > 0 {
+		text += `\n\n` + "You're watching these contracts:"
+
+		for _, contract := range user.Settings.Contracts {
+			text += `\n` + contractLabel(contract)
+		}
+	}
+
 	sendMessageWithKeyboard(user, text, default_keyboard, inline)
 }
 
@@ -296,7 +416,23 @@ func addAccount(user db.User) {
 
 	db.UpdateUserEditing(user.TelegramID, editing, adding)
 
-	text := "Enter the name of a REM account you'd like to monitor."
+	user.Settings.Flow = ""
+
+	// with several chains the account's chain is picked first
+	if len(chain.Chains()) > 1 {
+		user.Settings.Chain = ""
+		db.UpdateSettings(user.TelegramID, user.Settings)
+
+		text := "Which chain is the account on?"
+
+		sendMessageWithKeyboard(user, text, chainKeyboard(), inline)
+		return
+	}
+
+	user.Settings.Chain = chain.Default().Key
+	db.UpdateSettings(user.TelegramID, user.Settings)
+
+	text := "Enter the name of a " + chain.Default().Name + " account you'd like to monitor."
 
 	sendMessageWithKeyboard(user, text, cancel_keyboard, inline)
 }
@@ -313,8 +449,11 @@ func removeAccount(user db.User) {
 
 		db.UpdateUserEditing(user.TelegramID, editing, adding)
 
-		text = "Enter the name of a REM account you'd like to stop monitoring."
-		keyboard = cancel_keyboard
+		user.Settings.Flow = ""
+		db.UpdateSettings(user.TelegramID, user.Settings)
+
+		text = "Pick or enter the account you'd like to stop monitoring."
+		keyboard = accountKeyboard(user.Accounts)
 
 	} else {
 		text = "You aren't monitoring any accounts."
@@ -329,6 +468,12 @@ func cancelEditing(user db.User) {
 	adding := true
 	inline := false
 
+	// a flow left halfway would take over the next edit
+	user.Settings.Flow = ""
+	user.Settings.PendingAccount = ""
+	user.Settings.PendingContract = ""
+	db.UpdateSettings(user.TelegramID, user.Settings)
+
 	db.UpdateUserEditing(user.TelegramID, editing, adding)
 
 	text := "Ok, back to main menu."
@@ -340,22 +485,58 @@ func processEditing(user db.User, message string) {
 	keyboard := cancel_keyboard
 	inline := false
 
-	if len(message) < 1 && len(message) > 13 {
-		text = "Invalid account name. REM account names are between 1 and 12 characters long."
+	if user.Settings.Flow == contract_flow {
+		processContractEditing(user, message)
+		return
+	}
+
+	if user.Settings.Flow == resources_flow {
+		processResourceEditing(user, message)
+		return
+	}
+
+	if user.Settings.Flow == balances_flow {
+		processBalanceEditing(user, message)
+		return
+	}
+
+	if user.Settings.Flow == reliability_flow {
+		processReliabilityEditing(user, message)
+		return
+	}
+
+	c, chain_picked := chain.Get(user.Settings.Chain)
+
+	if user.Adding && !chain_picked {
+		// first answer of the add flow names the chain
+		if picked, ok := chain.Get(message); ok {
+			user.Settings.Chain = picked.Key
+			db.UpdateSettings(user.TelegramID, user.Settings)
+
+			text = "Enter the name of a " + picked.Name + " account you'd like to monitor."
+		} else {
+			text = "Please pick one of the listed chains."
+			keyboard = chainKeyboard()
+		}
+
+	} else if len(message) < 1 && len(message) > 13 {
+		text = "Invalid account name. Account names are between 1 and 12 characters long."
 	} else {
 
 		switch adding := user.Adding; adding {
 		case true: // adding an account
-			if accountExists(message) {
-				if stringInSlice(message, user.Accounts) {
+			scoped := chain.Scope(c.Key, message)
+
+			if accountExists(c, message) {
+				if stringInSlice(scoped, user.Accounts) {
 					text = "You are already monitoring this account."
 				} else {
-					text = "Added *" + message + "* account to your monitored list."
-					user.Accounts = append(user.Accounts, message)
+					text = "Added *" + message + "* account on " + c.Name + " to your monitored list."
+					user.Accounts = append(user.Accounts, scoped)
 					db.UpdateUserAccounts(user.TelegramID, user.Accounts)
 				}
 			} else {
-				text = "An account with that name does not exist."
+				text = "An account with that name does not exist on " + c.Name + "."
 			}
 		default: // removing an account
 			if stringInSlice(message, user.Accounts) {
@@ -365,6 +546,159 @@ func processEditing(user db.User, message string) {
 			} else {
 				text = "This account is not on your monitored list."
 			}
+
+			keyboard = accountKeyboard(user.Accounts)
+		}
+	}
+
+	sendMessageWithKeyboard(user, text, keyboard, inline)
+}
+
+func addContract(user db.User) {
+	editing := true
+	adding := true
+	inline := false
+
+	db.UpdateUserEditing(user.TelegramID, editing, adding)
+
+	user.Settings.Flow = contract_flow
+	user.Settings.PendingContract = ""
+
+	// with several chains the contract's chain is picked first
+	if len(chain.Chains()) > 1 {
+		user.Settings.Chain = ""
+		db.UpdateSettings(user.TelegramID, user.Settings)
+
+		text := "Which chain is the contract on?"
+
+		sendMessageWithKeyboard(user, text, chainKeyboard(), inline)
+		return
+	}
+
+	user.Settings.Chain = chain.Default().Key
+	db.UpdateSettings(user.TelegramID, user.Settings)
+
+	text := "Enter the name of a " + chain.Default().Name + " contract you'd like to watch."
+
+	sendMessageWithKeyboard(user, text, cancel_keyboard, inline)
+}
+
+func removeContract(user db.User) {
+	var text string
+	var keyboard [][]Button
+	inline := false
+
+	if len(user.Settings.Contracts) > 0 {
+
+		editing := true
+		adding := false
+
+		db.UpdateUserEditing(user.TelegramID, editing, adding)
+
+		user.Settings.Flow = contract_flow
+		db.UpdateSettings(user.TelegramID, user.Settings)
+
+		text = "Pick or enter the contract you'd like to stop watching."
+		keyboard = contractKeyboard(user.Settings.Contracts)
+
+	} else {
+		text = "You aren't watching any contracts."
+		keyboard = default_keyboard
+	}
+
+	sendMessageWithKeyboard(user, text, keyboard, inline)
+}
+
+// processContractEditing walks the contract flow: the chain, when there
+// are several, then the contract, then the actions to be told about
+func processContractEditing(user db.User, message string) {
+	var text string
+	keyboard := cancel_keyboard
+	inline := false
+
+	c, chain_picked := chain.Get(user.Settings.Chain)
+
+	if !user.Adding { // removing a contract
+		i := contractIndex(user.Settings.Contracts, message)
+
+		if i >= 0 {
+			text = "Stopped watching *" + message + "*."
+			user.Settings.Contracts = append(user.Settings.Contracts[:i], user.Settings.Contracts[i+1:]...)
+			db.UpdateSettings(user.TelegramID, user.Settings)
+		} else {
+			text = "This contract is not on your watched list."
+		}
+
+		keyboard = contractKeyboard(user.Settings.Contracts)
+
+	} else if !chain_picked {
+		if picked, ok := chain.Get(message); ok {
+			user.Settings.Chain = picked.Key
+			db.UpdateSettings(user.TelegramID, user.Settings)
+
+			text = "Enter the name of a " + picked.Name + " contract you'd like to watch."
+		} else {
+			text = "Please pick one of the listed chains."
+			keyboard = chainKeyboard()
+		}
+
+	} else if len(user.Settings.PendingContract) == 0 {
+		if !validAccount(message) {
+			text = "*" + escapeText(message) + "* is not a contract name, please try again."
+			sendMessageWithKeyboard(user, text, keyboard, inline)
+			return
+		}
+
+		abi, err := contractAbi(c, message)
+
+		if err != nil {
+			log.Print(err)
+			text = "Could not look up that contract, please try again."
+		} else if abi == nil {
+			text = "There is no contract deployed on *" + message + "* on " + c.Name + "."
+		} else {
+			user.Settings.PendingContract = chain.Scope(c.Key, message)
+			db.UpdateSettings(user.TelegramID, user.Settings)
+
+			text = "Which actions of *" + message + "* would you like to hear about? Enter their names separated by commas, or pick " + all_actions + "."
+			text += `\n\n` + "Its actions are: " + strings.Join(abiActions(abi), ", ")
+			keyboard = append([][]Button{[]Button{Button{Text: all_actions}}}, cancel_keyboard...)
+		}
+
+	} else {
+		_, name := chain.Unscope(user.Settings.PendingContract)
+		contract := db.Contract{Account: user.Settings.PendingContract}
+		unknown := []string{}
+
+		if message != all_actions {
+			abi, _ := contractAbi(c, name)
+
+			for _, action_name := range strings.FieldsFunc(message, func(r rune) bool { return r == ',' || r == ' ' }) {
+				if !validAccount(action_name) || (abi != nil && !stringInSlice(action_name, abiActions(abi))) {
+					unknown = append(unknown, escapeText(action_name))
+				} else if !stringInSlice(action_name, contract.Actions) {
+					contract.Actions = append(contract.Actions, action_name)
+				}
+			}
+		}
+
+		if len(unknown) > 0 {
+			text = "*" + name + "* has no " + strings.Join(unknown, ", ") + " action, please try again."
+			keyboard = append([][]Button{[]Button{Button{Text: all_actions}}}, cancel_keyboard...)
+		} else {
+			// watching a contract again replaces its actions
+			if i := contractIndex(user.Settings.Contracts, contract.Account); i >= 0 {
+				user.Settings.Contracts[i] = contract
+			} else {
+				user.Settings.Contracts = append(user.Settings.Contracts, contract)
+			}
+
+			user.Settings.PendingContract = ""
+			db.UpdateSettings(user.TelegramID, user.Settings)
+			db.UpdateUserEditing(user.TelegramID, false, true)
+
+			text = "Watching " + contractLabel(contract) + "."
+			keyboard = default_keyboard
 		}
 	}
 
@@ -391,6 +725,31 @@ func openSettingsMenu(user db.User) {
 				Text: alerts,
 			},
 		},
+		[]Button{
+			Button{
+				Text: voters,
+			},
+		},
+		[]Button{
+			Button{
+				Text: rewards,
+			},
+		},
+		[]Button{
+			Button{
+				Text: reliability,
+			},
+		},
+		[]Button{
+			Button{
+				Text: resources,
+			},
+		},
+		[]Button{
+			Button{
+				Text: balances,
+			},
+		},
 		[]Button{
 			Button{
 				Text: cancel,
@@ -424,6 +783,12 @@ func openNotificationSettings(user db.User) {
 				CallbackData: NotifyChanges,
 			},
 		},
+		[]Button{
+			Button{
+				Text:         markSelectedButton(user.Settings.Notification.Setting, NotifyStake),
+				CallbackData: NotifyStake,
+			},
+		},
 		[]Button{
 			Button{
 				Text:         markSelectedButton(user.Settings.Notification.Setting, NotifyStop),
@@ -497,65 +862,776 @@ func openReminderSettings(user db.User) {
 	sendMessageWithKeyboard(user, text, keyboard, inline)
 }
 
-func unknownCommand(user db.User) {
-	text := "Unknown command."
-	inline := false
-
-	sendMessageWithKeyboard(user, text, default_keyboard, inline)
-}
+// openVoterSettings is for producer owners, who hear when accounts
+// start or stop voting for them and how their votes changed each day
+func openVoterSettings(user db.User) {
+	text := "Please select the voter alerts you would like to receive for the producers you monitor."
+	inline := true
 
-func SendMessage(user db.User, text string) {
-	url := "https://api.telegram.org/bot" + config[api_key] + "/sendMessage"
-	var errs []error
+	keyboard := [][]Button{
+		[]Button{
+			Button{
+				Text:         markSelectedButton(user.Settings.Voters.Setting, VotersAll),
+				CallbackData: VotersAll,
+			},
+		},
+		[]Button{
+			Button{
+				Text:         markSelectedButton(user.Settings.Voters.Setting, VotersChanges),
+				CallbackData: VotersChanges,
+			},
+		},
+		[]Button{
+			Button{
+				Text:         markSelectedButton(user.Settings.Voters.Setting, VotersDigest),
+				CallbackData: VotersDigest,
+			},
+		},
+		[]Button{
+			Button{
+				Text:         markSelectedButton(user.Settings.Voters.Setting, VotersStop),
+				CallbackData: VotersStop,
+			},
+		},
+	}
 
-	data := `{"chat_id":"` + user.TelegramID + `", "text":"` + text + `", "parse_mode": "Markdown"}`
+	sendMessageWithKeyboard(user, text, keyboard, inline)
+}
 
-	request := gorequest.New()
-	_, _, errs = request.Post(url).Send(data).End()
+// openRewardSettings is for producer owners, who are reminded of
+// rewards waiting to be claimed with the amounts pending
+func openRewardSettings(user db.User) {
+	text := "Please select when you would like to be reminded to claim your producers' rewards."
+	inline := true
 
-	if errs != nil {
-		log.Print(errs)
+	keyboard := [][]Button{
+		[]Button{
+			Button{
+				Text:         markSelectedButton(user.Settings.Rewards.Setting, RewardsClaimable),
+				CallbackData: RewardsClaimable,
+			},
+		},
+		[]Button{
+			Button{
+				Text:         markSelectedButton(user.Settings.Rewards.Setting, RewardsThreeDays),
+				CallbackData: RewardsThreeDays,
+			},
+		},
+		[]Button{
+			Button{
+				Text:         markSelectedButton(user.Settings.Rewards.Setting, RewardsWeek),
+				CallbackData: RewardsWeek,
+			},
+		},
+		[]Button{
+			Button{
+				Text:         markSelectedButton(user.Settings.Rewards.Setting, RewardsStop),
+				CallbackData: RewardsStop,
+			},
+		},
 	}
-}
 
-func sendMessageWithKeyboard(user db.User, text string, keyboard [][]Button, inline bool) {
-	url := "https://api.telegram.org/bot" + config[api_key] + "/sendMessage"
-	var errs []error
-	var markup string
-	var body string
+	sendMessageWithKeyboard(user, text, keyboard, inline)
+}
 
-	k, err := json.Marshal(keyboard)
-	if err != nil {
-		panic(err)
-	}
+// openResourceSettings lists each account's RAM, CPU and NET
+// thresholds and asks which account to change
+func openResourceSettings(user db.User) {
+	var text string
+	var keyboard [][]Button
+	inline := false
 
-	if inline {
-		markup = `"inline_keyboard": ` + string(k)
-	} else {
-		markup = `"keyboard": ` + string(k)
-	}
+	if len(user.Accounts) > 0 {
 
-	data := `{"chat_id":"` + user.TelegramID + `", "text":"` + text + `", "parse_mode": "Markdown", "reply_markup": {` + markup + `, "resize_keyboard": true}}`
+		editing := true
+		adding := true
 
-	request := gorequest.New()
-	_, body, errs = request.Post(url).Send(data).End()
+		db.UpdateUserEditing(user.TelegramID, editing, adding)
 
-	if errs != nil {
-		log.Print(errs)
-	}
+		user.Settings.Flow = resources_flow
+		user.Settings.PendingAccount = ""
+		db.UpdateSettings(user.TelegramID, user.Settings)
 
-	if inline {
-		c := confirmation{}
+		text = "Resource alerts tell you when an account uses more of its RAM, CPU or NET than you allow:"
 
-		err = json.Unmarshal([]byte(body), &c)
-		if err != nil {
-			log.Print(err)
+		for _, account := range user.Accounts {
+			text += `\n` + "*" + account + "* " + resourcesLabel(user.Settings.Resources[account])
 		}
 
-		if strings.Contains(text, "producer alerts") {
-			user.Settings.Alert.MessageID = c.Message.ID
-		} else if strings.Contains(text, "guard") {
-			user.Settings.Reminder.MessageID = c.Message.ID
+		text += `\n\n` + "Pick the account you'd like to set thresholds for."
+		keyboard = accountKeyboard(user.Accounts)
+
+	} else {
+		text = "You aren't monitoring any accounts."
+		keyboard = default_keyboard
+	}
+
+	sendMessageWithKeyboard(user, text, keyboard, inline)
+}
+
+func processResourceEditing(user db.User, message string) {
+	var text string
+	keyboard := cancel_keyboard
+	inline := false
+
+	if len(user.Settings.PendingAccount) == 0 {
+		if stringInSlice(message, user.Accounts) {
+			user.Settings.PendingAccount = message
+			db.UpdateSettings(user.TelegramID, user.Settings)
+
+			text = "Enter the RAM, CPU and NET usage in percent to be alerted at, such as *80 90 90*, 0 skips a resource. Pick " + resources_off + " to stop resource alerts for *" + message + "*."
+			keyboard = append([][]Button{[]Button{Button{Text: resources_off}}}, cancel_keyboard...)
+		} else {
+			text = "This account is not on your monitored list."
+			keyboard = accountKeyboard(user.Accounts)
+		}
+
+		sendMessageWithKeyboard(user, text, keyboard, inline)
+		return
+	}
+
+	account := user.Settings.PendingAccount
+	thresholds, ok := parseResources(message)
+
+	if !ok {
+		text = "Please enter three numbers between 0 and 100, such as *80 90 90*."
+		keyboard = append([][]Button{[]Button{Button{Text: resources_off}}}, cancel_keyboard...)
+
+		sendMessageWithKeyboard(user, text, keyboard, inline)
+		return
+	}
+
+	if user.Settings.Resources == nil {
+		user.Settings.Resources = make(map[string]db.Resources)
+	}
+
+	if thresholds == (db.Resources{}) {
+		delete(user.Settings.Resources, account)
+		text = "Stopped resource alerts for *" + account + "*."
+	} else {
+		user.Settings.Resources[account] = thresholds
+		text = "Resource alerts for *" + account + "* " + resourcesLabel(thresholds) + "."
+	}
+
+	user.Settings.PendingAccount = ""
+	db.UpdateSettings(user.TelegramID, user.Settings)
+	db.UpdateUserEditing(user.TelegramID, false, true)
+
+	sendMessageWithKeyboard(user, text, default_keyboard, inline)
+}
+
+// parseResources reads "ram cpu net" percentages, "off" turns all of them off
+func parseResources(message string) (db.Resources, bool) {
+	if message == resources_off {
+		return db.Resources{}, true
+	}
+
+	fields := strings.FieldsFunc(message, func(r rune) bool { return r == ' ' || r == ',' || r == '%' })
+	if len(fields) != 3 {
+		return db.Resources{}, false
+	}
+
+	values := []int{}
+
+	for _, field := range fields {
+		v, err := strconv.Atoi(field)
+		if err != nil || v < 0 || v > 100 {
+			return db.Resources{}, false
+		}
+		values = append(values, v)
+	}
+
+	return db.Resources{RAM: values[0], CPU: values[1], NET: values[2]}, true
+}
+
+func resourcesLabel(r db.Resources) string {
+	if r == (db.Resources{}) {
+		return "off"
+	}
+
+	label := func(name string, threshold int) string {
+		if threshold == 0 {
+			return name + " off"
+		}
+		return name + " at " + strconv.Itoa(threshold) + "%"
+	}
+
+	return label("RAM", r.RAM) + ", " + label("CPU", r.CPU) + ", " + label("NET", r.NET)
+}
+
+func openBalanceSettings(user db.User) {
+	var text string
+	var keyboard [][]Button
+	inline := false
+
+	if len(user.Accounts) > 0 {
+
+		editing := true
+		adding := true
+
+		db.UpdateUserEditing(user.TelegramID, editing, adding)
+
+		user.Settings.Flow = balances_flow
+		user.Settings.PendingAccount = ""
+		db.UpdateSettings(user.TelegramID, user.Settings)
+
+		text = "Balance alerts tell you when an account holds less or more of a token than you'd like."
+
+		for _, band := range user.Settings.Balances {
+			text += `\n` + balanceLabel(band)
+		}
+
+		text += `\n\n` + "Pick the account you'd like to set a balance band for."
+		keyboard = accountKeyboard(user.Accounts)
+
+	} else {
+		text = "You aren't monitoring any accounts."
+		keyboard = default_keyboard
+	}
+
+	sendMessageWithKeyboard(user, text, keyboard, inline)
+}
+
+func processBalanceEditing(user db.User, message string) {
+	var text string
+	keyboard := append([][]Button{[]Button{Button{Text: resources_off}}}, cancel_keyboard...)
+	inline := false
+
+	if len(user.Settings.PendingAccount) == 0 {
+		if stringInSlice(message, user.Accounts) {
+			key, _ := chain.Unscope(message)
+			c, _ := chain.Get(key)
+
+			user.Settings.PendingAccount = message
+			db.UpdateSettings(user.TelegramID, user.Settings)
+
+			text = "Enter the lowest and highest balance you'd like *" + message + "* to hold, such as *100 5000*, a - leaves that side open."
+			text += " For a token other than " + c.Token + "'s add its symbol and contract, such as *100 5000 usdt tethertether*."
+			text += " Pick " + resources_off + " to stop balance alerts for the account."
+		} else {
+			text = "This account is not on your monitored list."
+			keyboard = accountKeyboard(user.Accounts)
+		}
+
+		sendMessageWithKeyboard(user, text, keyboard, inline)
+		return
+	}
+
+	account := user.Settings.PendingAccount
+	key, name := chain.Unscope(account)
+	c, _ := chain.Get(key)
+
+	if message == resources_off {
+		kept := []db.Balance{}
+		for _, band := range user.Settings.Balances {
+			if band.Account != account {
+				kept = append(kept, band)
+			}
+		}
+
+		user.Settings.Balances = kept
+		text = "Stopped balance alerts for *" + account + "*."
+	} else {
+		band, err := parseBalance(c, name, message)
+
+		if err != nil {
+			text = err.Error()
+			sendMessageWithKeyboard(user, text, keyboard, inline)
+			return
+		}
+
+		band.Account = account
+
+		// one band per account and token
+		replaced := false
+		for i, existing := range user.Settings.Balances {
+			if existing.Account == band.Account && existing.Contract == band.Contract && existing.Symbol == band.Symbol {
+				user.Settings.Balances[i] = band
+				replaced = true
+			}
+		}
+
+		if !replaced {
+			user.Settings.Balances = append(user.Settings.Balances, band)
+		}
+
+		text = "Balance alert set: " + balanceLabel(band) + "."
+	}
+
+	user.Settings.PendingAccount = ""
+	db.UpdateSettings(user.TelegramID, user.Settings)
+	db.UpdateUserEditing(user.TelegramID, false, true)
+
+	sendMessageWithKeyboard(user, text, default_keyboard, inline)
+}
+
+// parseBalance reads "min max [symbol contract]", without a symbol
+// the band is for the chain's own token
+func parseBalance(c *chain.Chain, account string, message string) (db.Balance, error) {
+	band := db.Balance{Contract: c.Token}
+	fields := strings.Fields(message)
+
+	if len(fields) != 2 && len(fields) != 4 {
+		return band, errors.New("Please enter a lowest and highest balance, such as *100 5000*.")
+	}
+
+	if len(fields) == 4 {
+		band.Symbol = strings.ToUpper(fields[2])
+		band.Contract = strings.ToLower(fields[3])
+
+		if !validSymbol(band.Symbol) {
+			return band, errors.New("*" + escapeText(fields[2]) + "* is not a token symbol, symbols are 1 to 7 letters.")
+		}
+
+		if !validAccount(band.Contract) {
+			return band, errors.New("*" + escapeText(fields[3]) + "* is not a contract name, please try again.")
+		}
+	}
+
+	// the token's precision, and the chain token's symbol, are read from a balance
+	precision, symbol, err := tokenPrecision(c, band.Contract, account, band.Symbol)
+	if err != nil {
+		if len(band.Symbol) > 0 {
+			return band, errors.New("Could not find " + band.Symbol + " on *" + band.Contract + "*, please check the symbol and contract.")
+		}
+		return band, errors.New("Could not tell which token *" + account + "* holds, please add its symbol and contract.")
+	}
+
+	band.Symbol = symbol
+
+	for i, field := range fields[:2] {
+		if field == "-" {
+			continue
+		}
+
+		amount, err := chain.ParseAmount(field, precision)
+		if err != nil || amount < 0 {
+			return band, errors.New("*" + escapeText(field) + "* is not an amount of " + band.Symbol + ", please try again.")
+		}
+
+		if i == 0 {
+			band.Min = field
+		} else {
+			band.Max = field
+		}
+	}
+
+	return band, nil
+}
+
+// tokenPrecision reads the precision and symbol of a token from the account's
+// balance, or from the token's stats when the account holds none
+func tokenPrecision(c *chain.Chain, contract string, account string, symbol string) (uint8, string, error) {
+	held := []string{}
+
+	err := c.Client.GetCurrencyBalance(contract, account, symbol, &held)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if len(held) > 0 {
+		_, precision, code, err := chain.ParseAsset(held[0])
+		return precision, code, err
+	}
+
+	if len(symbol) == 0 {
+		return 0, "", errors.New("balance: " + account + " holds no " + contract + " tokens")
+	}
+
+	q := chain.TableQuery{Code: contract, Scope: symbol, Table: "stat", Limit: 1}
+	stats := struct {
+		Rows []struct {
+			Supply string `json:"supply"`
+		} `json:"rows"`
+	}{}
+
+	err = c.Client.GetTableRows(q, &stats)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if len(stats.Rows) == 0 {
+		return 0, "", errors.New("balance: no " + symbol + " on " + contract)
+	}
+
+	_, precision, code, err := chain.ParseAsset(stats.Rows[0].Supply)
+	return precision, code, err
+}
+
+func balanceLabel(band db.Balance) string {
+	min := band.Min
+	if len(min) == 0 {
+		min = "-"
+	}
+
+	max := band.Max
+	if len(max) == 0 {
+		max = "-"
+	}
+
+	return "*" + band.Account + "* " + band.Symbol + " (" + band.Contract + ") between " + min + " and " + max
+}
+
+// showBalances lists every watched account's holdings of its chain's
+// token and of the tokens it has balance alerts for
+func showBalances(user db.User) {
+	var text string
+	inline := false
+
+	if len(user.Accounts) == 0 {
+		text = "You aren't monitoring any accounts."
+		sendMessageWithKeyboard(user, text, default_keyboard, inline)
+		return
+	}
+
+	text = "Current balances:"
+
+	for _, account := range user.Accounts {
+		key, name := chain.Unscope(account)
+
+		c, ok := chain.Get(key)
+		if !ok {
+			continue
+		}
+
+		contracts := []string{c.Token}
+		for _, band := range user.Settings.Balances {
+			if band.Account == account && !stringInSlice(band.Contract, contracts) {
+				contracts = append(contracts, band.Contract)
+			}
+		}
+
+		held := []string{}
+
+		for _, contract := range contracts {
+			balances := []string{}
+
+			err := c.Client.GetCurrencyBalance(contract, name, "", &balances)
+			if err != nil {
+				log.Print(err)
+				continue
+			}
+
+			held = append(held, balances...)
+		}
+
+		text += `\n\n` + "*" + account + "*"
+
+		if len(held) == 0 {
+			text += `\n` + "no tokens"
+		}
+
+		for _, balance := range held {
+			text += `\n` + balance
+		}
+	}
+
+	sendMessageWithKeyboard(user, text, default_keyboard, inline)
+}
+
+func openReliabilitySettings(user db.User) {
+	editing := true
+	adding := true
+	inline := false
+
+	db.UpdateUserEditing(user.TelegramID, editing, adding)
+
+	user.Settings.Flow = reliability_flow
+	db.UpdateSettings(user.TelegramID, user.Settings)
+
+	text := "Reliability alerts tell you when a producer you monitor produced less than a share of its expected blocks over the last 24 hours."
+
+	if user.Settings.Reliability > 0 {
+		text += " You're alerted below *" + strconv.Itoa(user.Settings.Reliability) + "%*."
+	}
+
+	text += `\n\n` + "Enter the percent to be alerted below, such as *95*, or pick " + resources_off + " to stop reliability alerts."
+	keyboard := append([][]Button{[]Button{Button{Text: resources_off}}}, cancel_keyboard...)
+
+	sendMessageWithKeyboard(user, text, keyboard, inline)
+}
+
+func processReliabilityEditing(user db.User, message string) {
+	var text string
+	inline := false
+
+	threshold := 0
+
+	if message != resources_off {
+		v, err := strconv.Atoi(strings.TrimSuffix(message, "%"))
+		if err != nil || v < 1 || v > 100 {
+			text = "Please enter a number between 1 and 100, such as *95*."
+			keyboard := append([][]Button{[]Button{Button{Text: resources_off}}}, cancel_keyboard...)
+
+			sendMessageWithKeyboard(user, text, keyboard, inline)
+			return
+		}
+		threshold = v
+	}
+
+	user.Settings.Reliability = threshold
+	db.UpdateSettings(user.TelegramID, user.Settings)
+	db.UpdateUserEditing(user.TelegramID, false, true)
+
+	if threshold == 0 {
+		text = "Stopped reliability alerts."
+	} else {
+		text = "You'll be alerted when a producer you monitor produces less than *" + strconv.Itoa(threshold) + "%* of its expected blocks."
+	}
+
+	sendMessageWithKeyboard(user, text, default_keyboard, inline)
+}
+
+// showProducer answers /producer <name> with the report registered by OnProducerCommand
+func showProducer(user db.User, account string) {
+	var text string
+	inline := false
+
+	key, name := chain.Unscope(strings.ToLower(account))
+	c, ok := chain.Get(key)
+
+	if len(name) == 0 || !ok {
+		text = "Please add the producer's name, such as *" + show_producer + " producer1*."
+	} else if producer_report == nil {
+		text = "Producer reports are not available right now."
+	} else if report, found := producer_report(c, name); found {
+		text = report
+	} else {
+		text = "*" + escapeText(name) + "* is not a registered producer."
+	}
+
+	sendMessageWithKeyboard(user, text, default_keyboard, inline)
+}
+
+// OnProducerCommand registers what /producer answers for a producer,
+// found is false when the chain has no such producer
+func OnProducerCommand(report func(c *chain.Chain, name string) (text string, found bool)) {
+	producer_report = report
+}
+
+// followSwap answers /swap, a swap key follows that swap and an account
+// every swap it starts or receives, without either the followed swaps are listed
+func followSwap(user db.User, target string) {
+	var text string
+	inline := false
+
+	if len(target) == 0 {
+		text = "Follow a swap with *" + follow_swap + " <key>*, or every swap an account starts or receives with *" + follow_swap + " <account>*."
+
+		for _, watch := range user.Settings.Swaps {
+			text += `\n` + swapLabel(watch)
+		}
+
+		sendMessageWithKeyboard(user, text, default_keyboard, inline)
+		return
+	}
+
+	watch, err := parseSwap(target)
+	if err != nil {
+		sendMessageWithKeyboard(user, err.Error(), default_keyboard, inline)
+		return
+	}
+
+	for _, existing := range user.Settings.Swaps {
+		if existing == watch {
+			text = "You're already following " + swapLabel(watch) + "."
+			sendMessageWithKeyboard(user, text, default_keyboard, inline)
+			return
+		}
+	}
+
+	c, _ := chain.Get(watch.Chain)
+
+	if len(watch.Key) > 0 && !swapExists(c, watch.Key) {
+		text = "There is no swap *" + watch.Key + "*."
+	} else if len(watch.Account) > 0 && !accountExists(c, watch.Account) {
+		text = "Account *" + watch.Account + "* does not exist."
+	} else {
+		user.Settings.Swaps = append(user.Settings.Swaps, watch)
+		db.UpdateSettings(user.TelegramID, user.Settings)
+
+		text = "Following " + swapLabel(watch) + ", you'll hear as approvals come in and when it is issued, finished or canceled. Stop with *" + unfollow_swap + " " + swapTarget(watch) + "*."
+	}
+
+	sendMessageWithKeyboard(user, text, default_keyboard, inline)
+}
+
+func unfollowSwap(user db.User, target string) {
+	var text string
+	inline := false
+
+	watch, err := parseSwap(target)
+	if err != nil {
+		sendMessageWithKeyboard(user, err.Error(), default_keyboard, inline)
+		return
+	}
+
+	kept := []db.Swap{}
+	for _, existing := range user.Settings.Swaps {
+		if existing != watch {
+			kept = append(kept, existing)
+		}
+	}
+
+	if len(kept) == len(user.Settings.Swaps) {
+		text = "You're not following " + swapLabel(watch) + "."
+	} else {
+		user.Settings.Swaps = kept
+		db.UpdateSettings(user.TelegramID, user.Settings)
+
+		text = "Stopped following " + swapLabel(watch) + "."
+	}
+
+	sendMessageWithKeyboard(user, text, default_keyboard, inline)
+}
+
+// parseSwap reads a swap key or an account, either may end in @<chain>,
+// swap keys are numbers
+func parseSwap(target string) (db.Swap, error) {
+	key, name := chain.Unscope(strings.ToLower(target))
+
+	c, ok := chain.Get(key)
+	if !ok {
+		return db.Swap{}, errors.New("Unknown chain *" + escapeText(key) + "*.")
+	}
+
+	if len(c.Swap) == 0 {
+		return db.Swap{}, errors.New(c.Name + " has no swap contract.")
+	}
+
+	if _, err := strconv.ParseUint(name, 10, 64); err == nil {
+		return db.Swap{Chain: c.Key, Key: name}, nil
+	}
+
+	if !validAccount(name) {
+		return db.Swap{}, errors.New("Please enter a swap key or an account name.")
+	}
+
+	return db.Swap{Chain: c.Key, Account: name}, nil
+}
+
+func swapLabel(watch db.Swap) string {
+	if len(watch.Key) > 0 {
+		return "swap *" + swapTarget(watch) + "*"
+	}
+	return "swaps of *" + swapTarget(watch) + "*"
+}
+
+// swapTarget is the followed swap key or account as /swap takes it
+func swapTarget(watch db.Swap) string {
+	if len(watch.Key) > 0 {
+		return chain.Scope(watch.Chain, watch.Key)
+	}
+	return chain.Scope(watch.Chain, watch.Account)
+}
+
+func swapExists(c *chain.Chain, key string) bool {
+	q := chain.TableQuery{Code: c.Swap, Scope: c.Swap, Table: "swaps", LowerBound: key, Limit: 1}
+	rows := struct {
+		Rows []struct {
+			Key json.Number `json:"key"`
+		} `json:"rows"`
+	}{}
+
+	err := c.Client.GetTableRows(q, &rows)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+
+	return len(rows.Rows) > 0 && string(rows.Rows[0].Key) == key
+}
+
+func unknownCommand(user db.User) {
+	text := "Unknown command."
+	inline := false
+
+	sendMessageWithKeyboard(user, text, default_keyboard, inline)
+}
+
+// SendMessage returns the id of the sent message,
+// empty when telegram did not accept it
+func SendMessage(user db.User, text string) string {
+	url := api_url + config[api_key] + "/sendMessage"
+	var errs []error
+	var body string
+
+	data := `{"chat_id":"` + user.TelegramID + `", "text":"` + text + `", "parse_mode": "Markdown"}`
+
+	request := gorequest.New()
+	_, body, errs = request.Post(url).Send(data).End()
+
+	if errs != nil {
+		log.Print(errs)
+		return ""
+	}
+
+	c := confirmation{}
+
+	err := json.Unmarshal([]byte(body), &c)
+	if err != nil {
+		log.Print(err)
+	}
+
+	return string(c.Message.ID)
+}
+
+func EditMessage(user db.User, message_id string, text string) {
+	url := api_url + config[api_key] + "/editMessageText"
+	var errs []error
+
+	data := `{"chat_id":"` + user.TelegramID + `", "message_id":"` + message_id + `", "text":"` + text + `", "parse_mode": "Markdown"}`
+
+	request := gorequest.New()
+	_, _, errs = request.Post(url).Send(data).End()
+
+	if errs != nil {
+		log.Print(errs)
+	}
+}
+
+func sendMessageWithKeyboard(user db.User, text string, keyboard [][]Button, inline bool) {
+	url := api_url + config[api_key] + "/sendMessage"
+	var errs []error
+	var markup string
+	var body string
+
+	k, err := json.Marshal(keyboard)
+	if err != nil {
+		panic(err)
+	}
+
+	if inline {
+		markup = `"inline_keyboard": ` + string(k)
+	} else {
+		markup = `"keyboard": ` + string(k)
+	}
+
+	data := `{"chat_id":"` + user.TelegramID + `", "text":"` + text + `", "parse_mode": "Markdown", "reply_markup": {` + markup + `, "resize_keyboard": true}}`
+
+	request := gorequest.New()
+	_, body, errs = request.Post(url).Send(data).End()
+
+	if errs != nil {
+		log.Print(errs)
+	}
+
+	if inline {
+		c := confirmation{}
+
+		err = json.Unmarshal([]byte(body), &c)
+		if err != nil {
+			log.Print(err)
+		}
+
+		if strings.Contains(text, "claim your producers' rewards") {
+			user.Settings.Rewards.MessageID = c.Message.ID
+		} else if strings.Contains(text, "voter alerts") {
+			user.Settings.Voters.MessageID = c.Message.ID
+		} else if strings.Contains(text, "producer alerts") {
+			user.Settings.Alert.MessageID = c.Message.ID
+		} else if strings.Contains(text, "guard") {
+			user.Settings.Reminder.MessageID = c.Message.ID
 		} else {
 			user.Settings.Notification.MessageID = c.Message.ID
 		}
@@ -565,7 +1641,7 @@ func sendMessageWithKeyboard(user db.User, text string, keyboard [][]Button, inl
 }
 
 func updateInlineKeyboard(user db.User, callback_id string, setting_type string) {
-	url := "https://api.telegram.org/bot" + config[api_key] + "/editMessageReplyMarkup"
+	url := api_url + config[api_key] + "/editMessageReplyMarkup"
 	var errs []error
 	var markup string
 	var message_id string
@@ -629,6 +1705,70 @@ func updateInlineKeyboard(user db.User, callback_id string, setting_type string)
 			},
 		}
 
+	} else if setting_type == "rewards" { // producer rewards
+
+		message_id = string(user.Settings.Rewards.MessageID)
+		notification = "Updated reward reminder settings."
+
+		keyboard = [][]Button{
+			[]Button{
+				Button{
+					Text:         markSelectedButton(user.Settings.Rewards.Setting, RewardsClaimable),
+					CallbackData: RewardsClaimable,
+				},
+			},
+			[]Button{
+				Button{
+					Text:         markSelectedButton(user.Settings.Rewards.Setting, RewardsThreeDays),
+					CallbackData: RewardsThreeDays,
+				},
+			},
+			[]Button{
+				Button{
+					Text:         markSelectedButton(user.Settings.Rewards.Setting, RewardsWeek),
+					CallbackData: RewardsWeek,
+				},
+			},
+			[]Button{
+				Button{
+					Text:         markSelectedButton(user.Settings.Rewards.Setting, RewardsStop),
+					CallbackData: RewardsStop,
+				},
+			},
+		}
+
+	} else if setting_type == "voters" { // producer voters
+
+		message_id = string(user.Settings.Voters.MessageID)
+		notification = "Updated voter alert settings."
+
+		keyboard = [][]Button{
+			[]Button{
+				Button{
+					Text:         markSelectedButton(user.Settings.Voters.Setting, VotersAll),
+					CallbackData: VotersAll,
+				},
+			},
+			[]Button{
+				Button{
+					Text:         markSelectedButton(user.Settings.Voters.Setting, VotersChanges),
+					CallbackData: VotersChanges,
+				},
+			},
+			[]Button{
+				Button{
+					Text:         markSelectedButton(user.Settings.Voters.Setting, VotersDigest),
+					CallbackData: VotersDigest,
+				},
+			},
+			[]Button{
+				Button{
+					Text:         markSelectedButton(user.Settings.Voters.Setting, VotersStop),
+					CallbackData: VotersStop,
+				},
+			},
+		}
+
 	} else { // account notification
 
 		message_id = string(user.Settings.Notification.MessageID)
@@ -653,6 +1793,12 @@ func updateInlineKeyboard(user db.User, callback_id string, setting_type string)
 					CallbackData: NotifyChanges,
 				},
 			},
+			[]Button{
+				Button{
+					Text:         markSelectedButton(user.Settings.Notification.Setting, NotifyStake),
+					CallbackData: NotifyStake,
+				},
+			},
 			[]Button{
 				Button{
 					Text:         markSelectedButton(user.Settings.Notification.Setting, NotifyStop),
@@ -682,24 +1828,10 @@ func updateInlineKeyboard(user db.User, callback_id string, setting_type string)
 	answerCallback(callback_id, notification)
 }
 
-func accountExists(name string) bool {
-	url := "https://rem.eon.llc/v1/chain/get_account"
-	var errs []error
-	var err error
-	var body string
-
-	data := `{"account_name":"` + name + `"}`
-
-	request := gorequest.New()
-	_, body, errs = request.Post(url).Send(data).End()
-
-	if errs != nil {
-		log.Print(errs)
-	}
-
+func accountExists(c *chain.Chain, name string) bool {
 	a := account{}
 
-	err = json.Unmarshal([]byte(body), &a)
+	err := c.Client.GetAccount(name, &a)
 	if err != nil {
 		log.Print(err)
 	}
@@ -712,8 +1844,94 @@ func accountExists(name string) bool {
 
 }
 
+func contractAbi(c *chain.Chain, name string) (*chain.ABI, error) {
+	a := chain.AbiResponse{}
+
+	err := c.Client.GetAbi(name, &a)
+
+	return a.Abi, err
+}
+
+func abiActions(abi *chain.ABI) []string {
+	names := []string{}
+	for _, action := range abi.Actions {
+		names = append(names, action.Name)
+	}
+	return names
+}
+
+func contractIndex(contracts []db.Contract, account string) int {
+	for i, contract := range contracts {
+		if contract.Account == account {
+			return i
+		}
+	}
+	return -1
+}
+
+func contractLabel(contract db.Contract) string {
+	key, name := chain.Unscope(contract.Account)
+	text := "*" + name + "*"
+
+	if c, ok := chain.Get(key); ok && c != chain.Default() {
+		text += " on " + c.Name
+	}
+
+	if len(contract.Actions) > 0 {
+		text += ", " + strings.Join(contract.Actions, ", ")
+	} else {
+		text += ", " + all_actions
+	}
+
+	return text
+}
+
+func contractKeyboard(contracts []db.Contract) [][]Button {
+	keyboard := [][]Button{}
+
+	for _, contract := range contracts {
+		keyboard = append(keyboard, []Button{
+			Button{
+				Text: contract.Account,
+			},
+		})
+	}
+
+	return append(keyboard, cancel_keyboard...)
+}
+
+func chainKeyboard() [][]Button {
+	keyboard := [][]Button{}
+
+	for _, c := range chain.Chains() {
+		keyboard = append(keyboard, []Button{
+			Button{
+				Text: c.Key,
+			},
+		})
+	}
+
+	return append(keyboard, cancel_keyboard...)
+}
+
+// accountKeyboard offers the user's accounts as stored,
+// so a tap removes exactly that account on its chain
+func accountKeyboard(accounts []string) [][]Button {
+	keyboard := [][]Button{}
+
+	for _, account := range accounts {
+		keyboard = append(keyboard, []Button{
+			Button{
+				Text: account,
+			},
+		})
+	}
+
+	return append(keyboard, cancel_keyboard...)
+}
+
 func answerCallback(callback_query_id string, text string) {
-	url := "https://api.telegram.org/bot" + config[api_key] + "/answerCallbackQuery"
+	url := api_url + config[api_key] + "/answerCallbackQuery"
 	var errs []error
 
 	data := `{"callback_query_id":"` + callback_query_id + `", "text":"` + text + `"}`
@@ -726,6 +1944,58 @@ func answerCallback(callback_query_id string, text string) {
 	}
 }
 
+// escapeText makes what a user typed safe to echo
+// in the json body and markdown of a message
+func escapeText(text string) string {
+	var b strings.Builder
+
+	for _, r := range text {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '"':
+			b.WriteString(`\"`)
+		case r == '_' || r == '*' || r == '`' || r == '[':
+			b.WriteString(`\\`)
+			b.WriteRune(r)
+		case r < 0x20:
+			continue
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// validSymbol tells whether text can be a token symbol
+func validSymbol(text string) bool {
+	if len(text) < 1 || len(text) > 7 {
+		return false
+	}
+
+	for _, r := range text {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// validAccount tells whether text can be an account name
+func validAccount(text string) bool {
+	if len(text) < 1 || len(text) > 12 {
+		return false
+	}
+
+	for _, r := range text {
+		if (r < 'a' || r > 'z') && (r < '1' || r > '5') && r != '.' {
+			return false
+		}
+	}
+	return true
+}
+
 func stringInSlice(a string, list []string) bool {
 	for _, b := range list {
 		if b == a {
@@ -0,0 +1,117 @@
+package watchman
+
+import (
+	"../chain"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// getAbi fetches a contract's abi, the chain cache keeps it between calls
+func getAbi(c *chain.Chain, account string) (*chain.ABI, error) {
+	a := chain.AbiResponse{}
+
+	err := c.Client.GetAbi(account, &a)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Abi == nil {
+		return nil, errors.New("get_abi: " + account + " has no contract")
+	}
+
+	return a.Abi, nil
+}
+
+// renderFields lists an action's fields in the order its abi declares them,
+// without the abi they are listed by name
+func renderFields(c *chain.Chain, a act) string {
+	names := []string{}
+
+	if abi, err := getAbi(c, a.Account); err == nil {
+		for _, field := range abi.ActionFields(a.Name) {
+			names = append(names, field.Name)
+		}
+	}
+
+	// fields the abi does not know of, such as the hex of undecoded data
+	rest := []string{}
+	for name := range a.Data {
+		if !stringInSlice(name, names) {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+
+	output := []string{}
+
+	for _, name := range append(names, rest...) {
+		value, ok := a.Data[name]
+		if !ok {
+			continue
+		}
+
+		output = append(output, fieldLabel(name)+": *"+escapeText(fieldValue(value))+"*")
+	}
+
+	return strings.Join(output, `\n`)
+}
+
+// fieldLabel turns an abi field name such as "max_supply" into "Max supply"
+func fieldLabel(name string) string {
+	label := strings.Replace(name, "_", " ", -1)
+
+	if len(label) == 0 {
+		return label
+	}
+
+	return strings.ToUpper(label[:1]) + label[1:]
+}
+
+func fieldValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return "-"
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+
+	return string(data)
+}
+
+// escapeText makes contract provided text safe to splice into the json
+// body and markdown of a telegram message, control characters are dropped
+func escapeText(text string) string {
+	var b strings.Builder
+
+	for _, r := range text {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '"':
+			b.WriteString(`\"`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '_' || r == '*' || r == '`' || r == '[':
+			b.WriteString(`\\`)
+			b.WriteRune(r)
+		case r < 0x20:
+			continue
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,145 @@
+package watchman
+
+import (
+	"../chain"
+	"../db"
+	"../telegram"
+	"log"
+	"time"
+)
+
+// band state is kept under balances/<telegram id>/<account>/<contract>/<symbol>
+const balance_state = "balances/"
+
+// balanceState is whether the balance was last seen outside its band,
+// "below" or "above", and the balance at the day's first check
+type balanceState struct {
+	Outside  string `json:"outside"`
+	Date     string `json:"date"`
+	DayStart int64  `json:"day_start"`
+}
+
+// CheckBalances alerts users whose accounts' balances leave the band they set
+func CheckBalances() {
+	users, err := db.GetActiveUsers(telegram.NotifyStop, telegram.AlertStop, telegram.RemindStop)
+	if err != nil {
+		log.Print(err)
+	}
+
+	for _, c := range chain.Chains() {
+		checkBalances(c, users)
+	}
+}
+
+func checkBalances(c *chain.Chain, users []db.User) {
+	for _, user := range users {
+		for _, band := range user.Settings.Balances {
+			key, account := chain.Unscope(band.Account)
+			if key != c.Key {
+				continue
+			}
+
+			balance, err := getBalance(c, band.Contract, account, band.Symbol)
+			if err != nil {
+				log.Print(err)
+				continue
+			}
+
+			checkBalance(c, user, account, band, balance)
+		}
+	}
+}
+
+func checkBalance(c *chain.Chain, user db.User, account string, band db.Balance, balance string) {
+	amount, precision, code, err := chain.ParseAsset(balance)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	key := balance_state + user.TelegramID + "/" + account + "/" + band.Contract + "/" + band.Symbol
+	state := balanceState{}
+
+	_, err = db.GetState(c.Key, key, &state)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	previous := state
+
+	today := time.Now().UTC().Format("2006-01-02")
+	if state.Date != today {
+		state.Date = today
+		state.DayStart = amount
+	}
+
+	state.Outside = ""
+	limit := ""
+
+	if min, err := chain.ParseAmount(band.Min, precision); err == nil && len(band.Min) > 0 && amount < min {
+		state.Outside = "below"
+		limit = "minimum of " + chain.FormatAsset(min, precision, code)
+	} else if max, err := chain.ParseAmount(band.Max, precision); err == nil && len(band.Max) > 0 && amount > max {
+		state.Outside = "above"
+		limit = "maximum of " + chain.FormatAsset(max, precision, code)
+	}
+
+	message := "_" + time.Now().Format("Mon Jan _2 15:04 2006 UTC") + "_"
+
+	if len(state.Outside) > 0 && state.Outside != previous.Outside {
+		message += `\n` + "Account " + accountLabel(c, account) + " holds *" + balance + "*, " + state.Outside + " your " + limit + "."
+		message += `\n` + "Change today: *" + signedAsset(amount-state.DayStart, precision, code) + "*"
+		telegram.SendMessage(user, message)
+	} else if len(state.Outside) == 0 && len(previous.Outside) > 0 {
+		message += `\n` + "Account " + accountLabel(c, account) + " holds *" + balance + "*, back within your band."
+		message += `\n` + "Change today: *" + signedAsset(amount-state.DayStart, precision, code) + "*"
+		telegram.SendMessage(user, message)
+	}
+
+	if state != previous {
+		db.SetState(c.Key, key, state)
+	}
+}
+
+// getBalance is the account's balance of symbol, zero when it holds none
+func getBalance(c *chain.Chain, contract string, account string, symbol string) (string, error) {
+	balances := []string{}
+
+	err := c.Client.GetCurrencyBalance(contract, account, symbol, &balances)
+	if err != nil {
+		return "", err
+	}
+
+	if len(balances) == 0 {
+		return zeroBalance(c, contract, symbol)
+	}
+
+	return balances[0], nil
+}
+
+// zeroBalance reads the token's precision from its stats table
+func zeroBalance(c *chain.Chain, contract string, symbol string) (string, error) {
+	q := chain.TableQuery{Code: contract, Scope: symbol, Table: "stat", Limit: 1}
+	stats := struct {
+		Rows []struct {
+			Supply string `json:"supply"`
+		} `json:"rows"`
+	}{}
+
+	err := c.Client.GetTableRows(q, &stats)
+	if err != nil || len(stats.Rows) == 0 {
+		return "", err
+	}
+
+	_, precision, code, err := chain.ParseAsset(stats.Rows[0].Supply)
+
+	return chain.FormatAsset(0, precision, code), err
+}
+
+func signedAsset(amount int64, precision uint8, code string) string {
+	if amount > 0 {
+		return "+" + chain.FormatAsset(amount, precision, code)
+	}
+	return chain.FormatAsset(amount, precision, code)
+}
@@ -0,0 +1,148 @@
+package watchman
+
+import (
+	"../chain"
+	"../db"
+	"../telegram"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// user cursors for contract notifications are kept apart
+// from the user's account notification cursor
+const contract_cursor = "contracts"
+
+type contractWatch struct {
+	user    db.User
+	actions []string
+}
+
+// sendContractNotifications sends every action executed on a watched
+// contract, by anyone, to the users watching it
+func sendContractNotifications(c *chain.Chain, users []db.User) {
+	watches := contractWatches(c, users)
+	if len(watches) == 0 {
+		return
+	}
+
+	contracts := []string{}
+	for contract := range watches {
+		contracts = append(contracts, contract)
+	}
+	sort.Strings(contracts)
+
+	cursor, started, err := readCursor(c, contract_cursor)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	if started {
+		return
+	}
+
+	a, err := getActions(c.Client, chain.ActionQuery{After: cursorTime(cursor), Contracts: strings.Join(contracts, ",")})
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	new_actions, next_cursor := pastCursor(cursor, a.Actions)
+
+	if len(new_actions) > 0 {
+		deliverContractNotifications(c, watches, new_actions)
+	}
+
+	if next_cursor != cursor {
+		db.UpdateCursor(next_cursor)
+	}
+}
+
+// contractWatches maps each contract on the chain to the users watching it
+func contractWatches(c *chain.Chain, users []db.User) map[string][]contractWatch {
+	watches := make(map[string][]contractWatch)
+
+	for _, user := range users {
+		if user.Settings.Notification.Setting == telegram.NotifyStop {
+			continue
+		}
+
+		for _, contract := range user.Settings.Contracts {
+			key, name := chain.Unscope(contract.Account)
+			if key == c.Key {
+				watches[name] = append(watches[name], contractWatch{user: user, actions: contract.Actions})
+			}
+		}
+	}
+
+	return watches
+}
+
+func deliverContractNotifications(c *chain.Chain, watches map[string][]contractWatch, new_actions []action) {
+	cursors, err := db.GetCursors(c.Key)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	// without a known LIB every block counts as reversible
+	lib, err := lastIrreversible(c.Client)
+	if err != nil {
+		log.Print(err)
+	}
+
+	for _, action := range new_actions {
+		seq := sequenceOf(action)
+
+		for _, watch := range watches[action.Act.Account] {
+			if len(watch.actions) > 0 && !stringInSlice(action.Act.Name, watch.actions) {
+				continue
+			}
+
+			owner := watch.user.TelegramID + "/" + contract_cursor
+
+			user_cursor, ok := cursors[owner]
+			if !ok {
+				user_cursor = db.Cursor{Chain: c.Key, Owner: owner}
+			}
+
+			if seq <= user_cursor.Sequence {
+				continue
+			}
+
+			sendNotification(c, watch.user, renderContractNotification(c, action), action, lib)
+
+			user_cursor.Sequence = seq
+			user_cursor.BlockNum, _ = strconv.ParseUint(string(action.BlockNum), 10, 64)
+			user_cursor.Timestamp = action.Timestamp
+
+			cursors[owner] = user_cursor
+			db.UpdateCursor(user_cursor)
+		}
+	}
+}
+
+func renderContractNotification(c *chain.Chain, action action) string {
+	message := "_" + time.Now().Format("Mon Jan _2 15:04 2006 UTC") + "_"
+	message += `\n` + "Contract " + accountLabel(c, action.Act.Account) + " executed *" + action.Act.Name + "*"
+
+	if len(action.Act.Authorizations) > 0 {
+		message += " authorized by *" + action.Act.Authorizations[0].Actor + "*"
+	}
+
+	message += "."
+
+	message_body := parseData(c, action.Act)
+	if len(message_body) > 0 {
+		message += `\n\n` + message_body
+	}
+
+	if len(c.Explorer) > 0 {
+		message += `\n\n` + "[View on " + c.ExplorerName + "](" + c.Explorer + action.TrxID + ")"
+	}
+
+	return message
+}
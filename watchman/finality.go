@@ -0,0 +1,158 @@
+package watchman
+
+import (
+	"../chain"
+	"../db"
+	"../telegram"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+)
+
+type chainInfo struct {
+	HeadBlockNum             uint64 `json:"head_block_num"`
+	HeadBlockTime            string `json:"head_block_time"`
+	LastIrreversibleBlockNum uint64 `json:"last_irreversible_block_num"`
+}
+
+type block struct {
+	Transactions []blockTransaction `json:"transactions"`
+}
+
+// trx is the transaction id for deferred transactions
+// and the whole packed transaction for signed ones
+type blockTransaction struct {
+	Trx json.RawMessage `json:"trx"`
+}
+
+type transactionLookup struct {
+	Actions []action `json:"actions"`
+}
+
+func lastIrreversible(c chain.Client) (uint64, error) {
+	i := chainInfo{}
+
+	err := c.GetInfo(&i)
+
+	return i.LastIrreversibleBlockNum, err
+}
+
+func blockStatus(block_num uint64, lib uint64) string {
+	block_num_s := strconv.FormatUint(block_num, 10)
+
+	if block_num <= lib {
+		return `\n\n` + "_Block " + block_num_s + " is irreversible._"
+	}
+
+	return `\n\n` + "_Block " + block_num_s + " is still reversible, a confirmation will follow._"
+}
+
+// confirmNotifications settles notifications whose block has passed
+// the last irreversible block: the original message is edited to say so,
+// or a retraction is sent when a fork dropped the transaction
+func confirmNotifications(c *chain.Chain) {
+	lib, err := lastIrreversible(c.Client)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	pending, err := db.GetPending(c.Key)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	blocks := make(map[uint64]map[string]bool)
+
+	for _, p := range pending {
+		// ordered by block, the rest is still reversible
+		if p.BlockNum > lib {
+			break
+		}
+
+		ids, ok := blocks[p.BlockNum]
+		if !ok {
+			ids, err = blockTransactions(c.Client, p.BlockNum)
+			if err != nil {
+				log.Print(err)
+				continue
+			}
+
+			blocks[p.BlockNum] = ids
+		}
+
+		user := db.User{TelegramID: p.TelegramID}
+
+		if ids[p.TrxID] {
+			telegram.EditMessage(user, p.MessageID, p.Text+blockStatus(p.BlockNum, lib))
+			db.DeletePending(p.ID)
+			continue
+		}
+
+		// a forked out transaction can still make it into a later block
+		moved_to, err := transactionBlock(c.Client, p.TrxID)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+
+		if moved_to > 0 && moved_to != p.BlockNum {
+			db.UpdatePendingBlock(p.ID, moved_to)
+			continue
+		}
+
+		telegram.EditMessage(user, p.MessageID, p.Text+`\n\n`+"_Dropped by a chain fork, this transaction did not happen._")
+
+		message := "_" + time.Now().Format("Mon Jan _2 15:04 2006 UTC") + "_"
+		message += `\n` + "A transaction reported earlier was dropped by a chain fork and never happened:"
+		message += `\n\n` + p.Text
+
+		telegram.SendMessage(user, message)
+		db.DeletePending(p.ID)
+	}
+}
+
+func blockTransactions(c chain.Client, block_num uint64) (map[string]bool, error) {
+	b := block{}
+	ids := make(map[string]bool)
+
+	err := c.GetBlock(block_num, &b)
+	if err != nil {
+		return ids, err
+	}
+
+	for _, t := range b.Transactions {
+		var id string
+
+		if json.Unmarshal(t.Trx, &id) != nil {
+			packed := struct {
+				ID string `json:"id"`
+			}{}
+
+			err = json.Unmarshal(t.Trx, &packed)
+			if err != nil {
+				return ids, err
+			}
+
+			id = packed.ID
+		}
+
+		ids[id] = true
+	}
+
+	return ids, nil
+}
+
+// transactionBlock is the block hyperion has the transaction in, 0 when unknown
+func transactionBlock(c chain.Client, trx_id string) (uint64, error) {
+	t := transactionLookup{}
+
+	err := c.GetTransaction(trx_id, &t)
+	if err != nil || len(t.Actions) == 0 {
+		return 0, err
+	}
+
+	return strconv.ParseUint(string(t.Actions[0].BlockNum), 10, 64)
+}
@@ -0,0 +1,109 @@
+package watchman
+
+import (
+	"../db"
+	"log"
+	"sync"
+)
+
+// subscriberIndex maps each monitored account, as stored with its
+// chain scope, to the users monitoring it, so an action is routed
+// by its actors instead of being checked against every user
+type subscriberIndex struct {
+	mu       sync.RWMutex
+	built    bool
+	accounts map[string]map[string]bool
+	users    map[string][]string
+}
+
+var subscribers = &subscriberIndex{}
+
+func init() {
+	db.OnAccountsUpdate(subscribers.update)
+}
+
+// lookup lists the telegram ids monitoring account,
+// the index is loaded from the db on first use
+func (x *subscriberIndex) lookup(account string) []string {
+	x.mu.RLock()
+	built := x.built
+	x.mu.RUnlock()
+
+	if !built {
+		x.build()
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	ids := make([]string, 0, len(x.accounts[account]))
+	for id := range x.accounts[account] {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+func (x *subscriberIndex) build() {
+	all, err := db.GetUserAccounts()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.built {
+		return
+	}
+
+	x.accounts = make(map[string]map[string]bool)
+	x.users = make(map[string][]string)
+
+	for telegram_id, accounts := range all {
+		x.set(telegram_id, accounts)
+	}
+
+	x.built = true
+}
+
+// update swaps one user's entries when their accounts are saved
+func (x *subscriberIndex) update(telegram_id string, accounts []string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	// not loaded yet, the db already has the change
+	if !x.built {
+		return
+	}
+
+	for _, account := range x.users[telegram_id] {
+		delete(x.accounts[account], telegram_id)
+
+		if len(x.accounts[account]) == 0 {
+			delete(x.accounts, account)
+		}
+	}
+
+	delete(x.users, telegram_id)
+
+	x.set(telegram_id, accounts)
+}
+
+// set adds a user's accounts, the caller holds mu
+func (x *subscriberIndex) set(telegram_id string, accounts []string) {
+	if len(accounts) == 0 {
+		return
+	}
+
+	x.users[telegram_id] = append([]string{}, accounts...)
+
+	for _, account := range accounts {
+		if x.accounts[account] == nil {
+			x.accounts[account] = make(map[string]bool)
+		}
+
+		x.accounts[account][telegram_id] = true
+	}
+}
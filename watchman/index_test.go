@@ -0,0 +1,144 @@
+package watchman
+
+import (
+	"../chain"
+	"../db"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+const (
+	bench_users   = 10000
+	bench_actions = 100
+)
+
+// benchUsers each monitor three accounts, the index is built from them
+func benchUsers() []db.User {
+	users := []db.User{}
+	subscribers = &subscriberIndex{built: true, accounts: make(map[string]map[string]bool), users: make(map[string][]string)}
+
+	for i := 0; i < bench_users; i++ {
+		id := strconv.Itoa(i)
+		user := db.User{TelegramID: id, Accounts: []string{"acc" + id + "a", "acc" + id + "b", "acc" + id + "c"}}
+
+		users = append(users, user)
+		subscribers.set(id, user.Accounts)
+	}
+
+	return users
+}
+
+// benchActions are transfers, every other one between monitored accounts
+func benchActions(t testing.TB) []action {
+	actions := []action{}
+
+	for i := 0; i < bench_actions; i++ {
+		from, to := "stranger", "nobody"
+		if i%2 == 0 {
+			from = "acc" + strconv.Itoa(i*97%bench_users) + "a"
+			to = "acc" + strconv.Itoa(i*31%bench_users) + "c"
+		}
+
+		a := action{}
+
+		data, _ := json.Marshal(transferAction(strconv.Itoa(i+1), "100", from, to))
+		if err := json.Unmarshal(data, &a); err != nil {
+			t.Fatal(err)
+		}
+
+		actions = append(actions, a)
+	}
+
+	return actions
+}
+
+// routeActionLoop is how actions were routed before the index,
+// every user's accounts checked against every action
+func routeActionLoop(c *chain.Chain, users []db.User, action action) ([]string, map[string][]string) {
+	order := []string{}
+	matched := make(map[string][]string)
+	involved := involvedAccounts(action)
+
+	for _, user := range users {
+		for _, account := range chain.AccountsOn(c.Key, user.Accounts) {
+			if !stringInSlice(account, involved) {
+				continue
+			}
+
+			if _, ok := matched[user.TelegramID]; !ok {
+				order = append(order, user.TelegramID)
+			}
+
+			matched[user.TelegramID] = append(matched[user.TelegramID], account)
+		}
+	}
+
+	return order, matched
+}
+
+func TestRouteActionMatchesLoop(t *testing.T) {
+	c := chain.Default()
+	users := benchUsers()
+	actions := benchActions(t)
+
+	active := make(map[string]db.User)
+	for _, user := range users {
+		active[user.TelegramID] = user
+	}
+
+	for _, a := range actions {
+		order, matched := routeAction(c, active, a)
+		loop_order, loop_matched := routeActionLoop(c, users, a)
+
+		if joinSorted(order) != joinSorted(loop_order) {
+			t.Fatalf("action %s routed to %v, the loop to %v", a.GlobalSequence, order, loop_order)
+		}
+
+		for _, id := range order {
+			if joinSorted(matched[id]) != joinSorted(loop_matched[id]) {
+				t.Errorf("user %s matched %v, the loop %v", id, matched[id], loop_matched[id])
+			}
+		}
+	}
+}
+
+func joinSorted(list []string) string {
+	sorted := append([]string{}, list...)
+	sort.Strings(sorted)
+
+	output := ""
+	for _, s := range sorted {
+		output += s + ","
+	}
+	return output
+}
+
+// BenchmarkDeliverNotifications routes a batch of actions to 10k users
+func BenchmarkDeliverNotifications(b *testing.B) {
+	c := chain.Default()
+	users := benchUsers()
+	actions := benchActions(b)
+
+	b.Run("loop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, a := range actions {
+				routeActionLoop(c, users, a)
+			}
+		}
+	})
+
+	b.Run("index", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			active := make(map[string]db.User, len(users))
+			for _, user := range users {
+				active[user.TelegramID] = user
+			}
+
+			for _, a := range actions {
+				routeAction(c, active, a)
+			}
+		}
+	})
+}
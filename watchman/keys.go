@@ -0,0 +1,103 @@
+package watchman
+
+import (
+	"../chain"
+	"../db"
+	"log"
+	"sort"
+	"time"
+)
+
+// each producer's signing key and punishment are kept under producer_keys
+const key_state = "producer_keys"
+
+// producerKey is the signing key last seen for a producer
+// and the end of a punishment still running then
+type producerKey struct {
+	Key           string `json:"key"`
+	PunishedUntil string `json:"punished_until"`
+}
+
+// sendKeyAlerts reports producers whose signing key changed, which is a
+// rotation or a compromise, and punishments as they start and end
+func sendKeyAlerts(c *chain.Chain, users []db.User) {
+	all, err := getAllProducers(c.Client.Fresh(), c.System)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	previous := make(map[string]producerKey)
+
+	found, err := db.GetState(c.Key, key_state, &previous)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	now := time.Now().UTC()
+	current := make(map[string]producerKey)
+
+	key_changes := make(map[string]string)
+	punishments := make(map[string]string)
+
+	for _, p := range all.Producers {
+		state := producerKey{Key: p.ProducerKey}
+
+		until, err := time.Parse("2006-01-02T15:04:05.9", p.PunishedUntil)
+		if err == nil && until.After(now) {
+			state.PunishedUntil = p.PunishedUntil
+		}
+
+		before, seen := previous[p.Owner]
+
+		// an inactive producer keeps the state it was last active with,
+		// what changed meanwhile is reported once it is active again
+		if p.IsActive != 1 {
+			if found && seen {
+				current[p.Owner] = before
+			} else {
+				current[p.Owner] = state
+			}
+			continue
+		}
+
+		current[p.Owner] = state
+
+		if !found || !seen {
+			continue
+		}
+
+		if before.Key != state.Key {
+			key_changes[p.Owner] = "*" + p.Owner + "* changed its signing key from `" + before.Key + "` to `" + state.Key + "`."
+		}
+
+		if len(state.PunishedUntil) > 0 && state.PunishedUntil != before.PunishedUntil {
+			punishments[p.Owner] = "*" + p.Owner + "* is punished until *" + until.Format("Mon Jan _2 15:04 2006 UTC") + "*."
+		} else if len(state.PunishedUntil) == 0 && len(before.PunishedUntil) > 0 {
+			punishments[p.Owner] = "*" + p.Owner + "* is no longer punished."
+		}
+	}
+
+	db.SetState(c.Key, key_state, current)
+
+	// a key change may be a compromise, it is sent while snoozing too
+	if len(key_changes) > 0 {
+		sendProducerChanges(c, users, "Producer signing keys"+chainLabel(c)+" changed:", sortedKeys(key_changes), key_changes, false)
+	}
+
+	if len(punishments) > 0 {
+		sendProducerChanges(c, users, "Producer punishments"+chainLabel(c)+":", sortedKeys(punishments), punishments, true)
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,397 @@
+package watchman
+
+import (
+	"../chain"
+	"../db"
+	"../telegram"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	msig_cursor = "msig"
+	// proposal state is kept under msig/<proposer>/<proposal name>
+	msig_state = "msig/"
+
+	propose_s   = "propose"
+	approve_s   = "approve"
+	unapprove_s = "unapprove"
+	cancel_s    = "cancel"
+	exec_s      = "exec"
+)
+
+var msig_actions_to_watch = []string{
+	propose_s, approve_s, unapprove_s, cancel_s, exec_s,
+}
+
+// proposal is what we know of a msig proposal and the
+// message each involved user was sent about it
+type proposal struct {
+	Proposer  string            `json:"proposer"`
+	Name      string            `json:"name"`
+	Requested []string          `json:"requested"`
+	Approved  []string          `json:"approved"`
+	Actions   []proposalAction  `json:"actions"`
+	Status    string            `json:"status"`
+	By        string            `json:"by"`
+	TrxID     string            `json:"trx_id"`
+	Messages  map[string]string `json:"messages"`
+}
+
+type proposalAction struct {
+	Account       string                 `json:"account"`
+	Name          string                 `json:"name"`
+	Authorization []string               `json:"authorization"`
+	Data          map[string]interface{} `json:"data"`
+}
+
+type msigData struct {
+	Proposer     string          `json:"proposer"`
+	ProposalName string          `json:"proposal_name"`
+	Requested    []authorization `json:"requested"`
+	Level        authorization   `json:"level"`
+	Canceler     string          `json:"canceler"`
+	Executer     string          `json:"executer"`
+	Trx          struct {
+		Actions []struct {
+			Account       string          `json:"account"`
+			Name          string          `json:"name"`
+			Authorization []authorization `json:"authorization"`
+			Data          json.RawMessage `json:"data"`
+		} `json:"actions"`
+	} `json:"trx"`
+}
+
+type approvalsRows struct {
+	Rows []struct {
+		ProposalName       string            `json:"proposal_name"`
+		RequestedApprovals []json.RawMessage `json:"requested_approvals"`
+		ProvidedApprovals  []json.RawMessage `json:"provided_approvals"`
+	} `json:"rows"`
+}
+
+type proposalRows struct {
+	Rows []struct {
+		ProposalName      string `json:"proposal_name"`
+		PackedTransaction string `json:"packed_transaction"`
+	} `json:"rows"`
+}
+
+// sendProposalNotifications follows the msig contract and keeps one
+// message per proposal and user up to date with its progress
+func sendProposalNotifications(c *chain.Chain, users []db.User) {
+	if len(c.Msig) == 0 {
+		return
+	}
+
+	cursor, started, err := readCursor(c, msig_cursor)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	if started {
+		return
+	}
+
+	a, err := getActions(c.Client, chain.ActionQuery{After: cursorTime(cursor), Contracts: c.Msig, Names: strings.Join(msig_actions_to_watch, ",")})
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	new_actions, next_cursor := pastCursor(cursor, a.Actions)
+
+	for _, action := range new_actions {
+		trackProposal(c, users, action)
+	}
+
+	if next_cursor != cursor {
+		db.UpdateCursor(next_cursor)
+	}
+}
+
+func trackProposal(c *chain.Chain, users []db.User, action action) {
+	d := msigData{}
+
+	data, _ := json.Marshal(action.Act.Data)
+	err := json.Unmarshal(data, &d)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	key := msig_state + d.Proposer + "/" + d.ProposalName
+	p := proposal{}
+
+	found, err := db.GetState(c.Key, key, &p)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	switch action.Act.Name {
+	case propose_s:
+		// a proposal name is free again once executed or canceled
+		p = proposal{Proposer: d.Proposer, Name: d.ProposalName, Status: "open", TrxID: action.TrxID}
+
+		for _, level := range d.Requested {
+			p.Requested = append(p.Requested, level.Actor+"@"+level.Permission)
+		}
+
+		for _, a := range d.Trx.Actions {
+			pa := proposalAction{Account: a.Account, Name: a.Name, Data: proposalData(c, a.Account, a.Name, a.Data)}
+
+			for _, level := range a.Authorization {
+				pa.Authorization = append(pa.Authorization, level.Actor+"@"+level.Permission)
+			}
+
+			p.Actions = append(p.Actions, pa)
+		}
+	default:
+		// proposed before we were following, read it from the contract
+		if !found {
+			p, err = loadProposal(c, d.Proposer, d.ProposalName)
+			if err != nil {
+				log.Print(err)
+				return
+			}
+		}
+	}
+
+	switch action.Act.Name {
+	case approve_s:
+		level := d.Level.Actor + "@" + d.Level.Permission
+		if !stringInSlice(level, p.Approved) {
+			p.Approved = append(p.Approved, level)
+		}
+	case unapprove_s:
+		level := d.Level.Actor + "@" + d.Level.Permission
+		for i, approved := range p.Approved {
+			if approved == level {
+				p.Approved = append(p.Approved[:i], p.Approved[i+1:]...)
+				break
+			}
+		}
+	case cancel_s:
+		p.Status = "canceled"
+		p.By = d.Canceler
+	case exec_s:
+		p.Status = "executed"
+		p.By = d.Executer
+	}
+
+	if p.Messages == nil {
+		p.Messages = make(map[string]string)
+	}
+
+	involved := p.involved()
+	text := renderProposal(c, p)
+
+	for _, user := range users {
+		if user.Settings.Notification.Setting == telegram.NotifyStop {
+			continue
+		}
+
+		message_id, sent := p.Messages[user.TelegramID]
+
+		if sent {
+			telegram.EditMessage(user, message_id, text)
+			continue
+		}
+
+		for _, account := range chain.AccountsOn(c.Key, user.Accounts) {
+			if stringInSlice(account, involved) {
+				// a failed send is tried again on the proposal's next action
+				if message_id := telegram.SendMessage(user, text); len(message_id) > 0 {
+					p.Messages[user.TelegramID] = message_id
+				}
+				break
+			}
+		}
+	}
+
+	// settled proposals need no more updates, and proposals no message
+	// was sent about are read from the contract again when needed
+	if p.Status != "open" || len(p.Messages) == 0 {
+		if found {
+			db.DeleteState(c.Key, key)
+		}
+		return
+	}
+
+	db.SetState(c.Key, key, p)
+}
+
+// involved lists the proposer and every account whose
+// approval is requested or that authorizes a proposed action
+func (p proposal) involved() []string {
+	accounts := []string{p.Proposer}
+
+	levels := append([]string{}, p.Requested...)
+	for _, a := range p.Actions {
+		levels = append(levels, a.Authorization...)
+	}
+
+	for _, level := range levels {
+		actor := strings.Split(level, "@")[0]
+		if !stringInSlice(actor, accounts) {
+			accounts = append(accounts, actor)
+		}
+	}
+
+	return accounts
+}
+
+func renderProposal(c *chain.Chain, p proposal) string {
+	message := "_" + time.Now().Format("Mon Jan _2 15:04 2006 UTC") + "_"
+	message += `\n` + "Multisig proposal *" + p.Name + "* by " + accountLabel(c, p.Proposer)
+
+	switch p.Status {
+	case "executed":
+		message += `\n` + "Status: *executed* by *" + p.By + "*"
+	case "canceled":
+		message += `\n` + "Status: *canceled* by *" + p.By + "*"
+	default:
+		message += `\n` + "Status: *open*"
+	}
+
+	message += `\n\n` + "Approvals: *" + strconv.Itoa(len(p.Approved)) + " of " + strconv.Itoa(len(p.Requested)) + "*"
+
+	for _, level := range p.Requested {
+		if stringInSlice(level, p.Approved) {
+			message += `\n` + "\xe2\x9c\x85 " + level
+		} else {
+			message += `\n` + "\xe2\x96\xab " + level
+		}
+	}
+
+	if len(p.Actions) > 0 {
+		message += `\n\n` + "Transaction:"
+
+		for _, a := range p.Actions {
+			message += `\n` + "*" + a.Account + "* \xe2\x86\x92 *" + a.Name + "* by " + strings.Join(a.Authorization, ", ")
+
+			fields := renderFields(c, act{Account: a.Account, Name: a.Name, Data: a.Data})
+			if len(fields) > 0 {
+				message += `\n` + fields
+			}
+		}
+	}
+
+	if len(c.Explorer) > 0 && len(p.TrxID) > 0 {
+		message += `\n\n` + "[View proposal on " + c.ExplorerName + "](" + c.Explorer + p.TrxID + ")"
+	}
+
+	return message
+}
+
+// proposalData decodes a proposed action's data, hyperion
+// leaves it as hex when it did not decode it itself
+func proposalData(c *chain.Chain, account string, action_name string, raw json.RawMessage) map[string]interface{} {
+	decoded := make(map[string]interface{})
+	if json.Unmarshal(raw, &decoded) == nil {
+		return decoded
+	}
+
+	var hex_data string
+	json.Unmarshal(raw, &hex_data)
+
+	data, err := hex.DecodeString(hex_data)
+	if err != nil {
+		return map[string]interface{}{"hex": hex_data}
+	}
+
+	return decodeActionData(c, account, action_name, data)
+}
+
+// loadProposal reads a proposal and its approvals from the msig contract's tables
+func loadProposal(c *chain.Chain, proposer string, name string) (proposal, error) {
+	p := proposal{Proposer: proposer, Name: name, Status: "open"}
+
+	q := chain.TableQuery{Code: c.Msig, Scope: proposer, Table: "approvals2", LowerBound: name, Limit: 1}
+	approvals := approvalsRows{}
+
+	err := c.Client.GetTableRows(q, &approvals)
+	if err != nil {
+		return p, err
+	}
+
+	// contracts before approvals2 keep bare permission levels
+	if len(approvals.Rows) == 0 || approvals.Rows[0].ProposalName != name {
+		q.Table = "approvals"
+
+		err = c.Client.GetTableRows(q, &approvals)
+		if err != nil {
+			return p, err
+		}
+	}
+
+	// levels move from requested to provided once they approve,
+	// the proposal requested both
+	if len(approvals.Rows) > 0 && approvals.Rows[0].ProposalName == name {
+		for _, level := range approvals.Rows[0].RequestedApprovals {
+			p.Requested = append(p.Requested, approvalLevel(level))
+		}
+
+		for _, level := range approvals.Rows[0].ProvidedApprovals {
+			p.Requested = append(p.Requested, approvalLevel(level))
+			p.Approved = append(p.Approved, approvalLevel(level))
+		}
+	}
+
+	q.Table = "proposal"
+	proposals := proposalRows{}
+
+	err = c.Client.GetTableRows(q, &proposals)
+	if err != nil {
+		return p, err
+	}
+
+	if len(proposals.Rows) == 0 || proposals.Rows[0].ProposalName != name {
+		return p, nil
+	}
+
+	packed, err := hex.DecodeString(proposals.Rows[0].PackedTransaction)
+	if err != nil {
+		return p, err
+	}
+
+	actions, err := chain.UnpackTransaction(packed)
+	if err != nil {
+		return p, err
+	}
+
+	for _, a := range actions {
+		pa := proposalAction{Account: a.Account, Name: a.Name, Data: decodeActionData(c, a.Account, a.Name, a.Data)}
+
+		for _, level := range a.Authorization {
+			pa.Authorization = append(pa.Authorization, level.Actor+"@"+level.Permission)
+		}
+
+		p.Actions = append(p.Actions, pa)
+	}
+
+	return p, nil
+}
+
+// approvalLevel reads an approvals2 entry, {level, time},
+// or a bare permission level of the older approvals table
+func approvalLevel(raw json.RawMessage) string {
+	entry := struct {
+		Level authorization `json:"level"`
+	}{}
+
+	if json.Unmarshal(raw, &entry) == nil && len(entry.Level.Actor) > 0 {
+		return entry.Level.Actor + "@" + entry.Level.Permission
+	}
+
+	level := authorization{}
+	json.Unmarshal(raw, &level)
+
+	return level.Actor + "@" + level.Permission
+}
@@ -0,0 +1,241 @@
+package watchman
+
+import (
+	"../chain"
+	"../db"
+	"../telegram"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// the last ranking seen is kept under ranks
+	rank_state = "ranks"
+	// producers elected to the active schedule
+	active_producers = 21
+)
+
+// rankState is each active producer's rank, which producers were last
+// seen within the margin of the cutoff, and which were seen entering
+// or leaving the top 21 once and wait for the next read to agree
+type rankState struct {
+	Ranks   map[string]int  `json:"ranks"`
+	Near    map[string]bool `json:"near"`
+	Pending map[string]bool `json:"pending"`
+}
+
+// sendRankAlerts reports producers changing rank, entering or leaving
+// the top 21 and coming within the chain's rank margin of the cutoff.
+// A node behind the chain could report a ranking already voted away,
+// so entering or leaving the top 21 is only sent once two reads agree.
+func sendRankAlerts(c *chain.Chain, users []db.User) {
+	all, err := getAllProducers(c.Client.Fresh(), c.System)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	ranked, votes := rankProducers(all)
+
+	current := rankState{Ranks: make(map[string]int), Near: make(map[string]bool)}
+	for i, owner := range ranked {
+		current.Ranks[owner] = i + 1
+	}
+
+	// the vote weight on either side of the cutoff
+	if len(ranked) > active_producers {
+		last_in := votes[ranked[active_producers-1]]
+		first_out := votes[ranked[active_producers]]
+		margin := last_in * c.RankMargin / 100
+
+		for i, owner := range ranked {
+			if i < active_producers && votes[owner]-first_out <= margin {
+				current.Near[owner] = true
+			} else if i >= active_producers && last_in-votes[owner] <= margin {
+				current.Near[owner] = true
+			}
+		}
+	}
+
+	previous := rankState{}
+
+	found, err := db.GetState(c.Key, rank_state, &previous)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	// the first run only records the ranking
+	if !found {
+		db.SetState(c.Key, rank_state, current)
+		return
+	}
+
+	// what is saved keeps the last agreed rank of unconfirmed moves
+	next := rankState{Ranks: make(map[string]int), Near: current.Near, Pending: make(map[string]bool)}
+	for owner, rank := range current.Ranks {
+		next.Ranks[owner] = rank
+	}
+
+	changes := make(map[string]string)
+
+	for _, owner := range ranked {
+		before := previous.Ranks[owner]
+
+		if crossedCutoff(before, current.Ranks[owner]) && !confirmCrossing(previous, next, owner, before, current.Ranks[owner] <= active_producers) {
+			continue
+		}
+
+		change := rankChange(owner, before, current.Ranks[owner])
+
+		if current.Near[owner] && !previous.Near[owner] {
+			if len(change) > 0 {
+				change += `\n`
+			}
+			change += "*" + owner + "* is within " + strconv.FormatFloat(c.RankMargin, 'f', -1, 64) + "% of the top " + strconv.Itoa(active_producers) + " cutoff at rank *" + strconv.Itoa(current.Ranks[owner]) + "*."
+		}
+
+		if len(change) > 0 {
+			changes[owner] = change
+		}
+	}
+
+	// producers that unregistered drop out of the ranking
+	for owner, rank := range previous.Ranks {
+		if _, ok := current.Ranks[owner]; !ok && rank <= active_producers {
+			if !confirmCrossing(previous, next, owner, rank, false) {
+				continue
+			}
+			changes[owner] = "*" + owner + "* left the top " + strconv.Itoa(active_producers) + " and is no longer active."
+		}
+	}
+
+	db.SetState(c.Key, rank_state, next)
+
+	if len(changes) == 0 {
+		return
+	}
+
+	owners := []string{}
+	for owner := range changes {
+		owners = append(owners, owner)
+	}
+
+	// best ranked first, unregistered ones last
+	sort.Slice(owners, func(i, j int) bool {
+		a, b := current.Ranks[owners[i]], current.Ranks[owners[j]]
+		if a == 0 || b == 0 {
+			return b == 0 && a != 0
+		}
+		return a < b
+	})
+
+	sendProducerChanges(c, users, "Producer ranking"+chainLabel(c)+" changed:", owners, changes, true)
+}
+
+// sendProducerChanges sends each user following producers, all or only
+// their own, one message listing the changes of those producers in order
+func sendProducerChanges(c *chain.Chain, users []db.User, title string, owners []string, changes map[string]string, snoozable bool) {
+	for _, user := range users {
+		setting := user.Settings.Alert.Setting
+		if setting != telegram.AlertAll && setting != telegram.AlertPersonal {
+			continue
+		}
+
+		snooze, err := time.Parse("2006-01-02T15:04:05.9", user.Settings.Alert.Snooze)
+		if snoozable && err == nil && time.Now().Before(snooze) {
+			continue
+		}
+
+		accounts := chain.AccountsOn(c.Key, user.Accounts)
+		message := ""
+
+		for _, owner := range owners {
+			if setting == telegram.AlertPersonal && !stringInSlice(owner, accounts) {
+				continue
+			}
+
+			message += `\n` + changes[owner]
+		}
+
+		if len(message) == 0 {
+			continue
+		}
+
+		header := "_" + time.Now().Format("Mon Jan _2 15:04 2006 UTC") + "_"
+		header += `\n` + title
+
+		telegram.SendMessage(user, header+message)
+	}
+}
+
+// crossedCutoff tells whether a move between two ranks, 0 is
+// unranked, enters or leaves the top 21
+func crossedCutoff(before int, after int) bool {
+	in_before := before > 0 && before <= active_producers
+	in_after := after > 0 && after <= active_producers
+	return in_before != in_after
+}
+
+// confirmCrossing tells whether the previous read saw the producer cross the
+// cutoff the same way. Otherwise the crossing waits for the next read, and
+// the rank and margin it had before are saved again.
+func confirmCrossing(previous rankState, next rankState, owner string, before int, in_top bool) bool {
+	if seen, ok := previous.Pending[owner]; ok && seen == in_top {
+		return true
+	}
+
+	next.Pending[owner] = in_top
+	next.Near[owner] = previous.Near[owner]
+
+	if before > 0 {
+		next.Ranks[owner] = before
+	} else {
+		delete(next.Ranks, owner)
+	}
+	return false
+}
+
+// rankChange describes a producer's move between two ranks, 0 is unranked
+func rankChange(owner string, before int, after int) string {
+	top := strconv.Itoa(active_producers)
+
+	switch {
+	case before == after:
+		return ""
+	case after <= active_producers && (before == 0 || before > active_producers):
+		return "*" + owner + "* entered the top " + top + " at rank *" + strconv.Itoa(after) + "*."
+	case after > active_producers && before > 0 && before <= active_producers:
+		return "*" + owner + "* left the top " + top + ", now at rank *" + strconv.Itoa(after) + "*."
+	case before == 0:
+		return "*" + owner + "* is ranked *" + strconv.Itoa(after) + "*."
+	default:
+		return "*" + owner + "* moved from rank " + strconv.Itoa(before) + " to *" + strconv.Itoa(after) + "*."
+	}
+}
+
+// rankProducers orders the active producers by their votes
+func rankProducers(all producers) ([]string, map[string]float64) {
+	ranked := []string{}
+	votes := make(map[string]float64)
+
+	for _, p := range all.Producers {
+		if p.IsActive != 1 {
+			continue
+		}
+
+		ranked = append(ranked, p.Owner)
+		votes[p.Owner], _ = strconv.ParseFloat(p.TotalVotes, 64)
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if votes[ranked[i]] == votes[ranked[j]] {
+			return ranked[i] < ranked[j]
+		}
+		return votes[ranked[i]] > votes[ranked[j]]
+	})
+
+	return ranked, votes
+}
@@ -0,0 +1,305 @@
+package watchman
+
+import (
+	"../chain"
+	"../db"
+	"../telegram"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	// block counts are kept under reliability/<producer>, and which
+	// producers a user was alerted about under reliability_alerts/<telegram id>
+	reliability_state       = "reliability/"
+	reliability_alert_state = "reliability_alerts/"
+	// snapshots older than the longest window are dropped
+	reliability_keep = time.Hour * 24 * 31
+	// a producer has to get this many points above the
+	// threshold again before its alert is cleared
+	reliability_hysteresis = 1
+)
+
+// the windows /producer shows, the first one is alerted on
+var reliability_windows = []struct {
+	Name   string
+	Length time.Duration
+}{
+	{"24h", time.Hour * 24},
+	{"7d", time.Hour * 24 * 7},
+	{"30d", time.Hour * 24 * 30},
+}
+
+// blockHistory counts expected and produced blocks from the first snapshot on.
+// The producer row's counters start over when rewards are claimed, the last
+// raw values and claim time tell a reset from blocks being produced.
+type blockHistory struct {
+	Snapshots   []blockSnapshot `json:"snapshots"`
+	RawExpected int             `json:"raw_expected"`
+	RawUnpaid   int             `json:"raw_unpaid"`
+	LastUpdate  string          `json:"last_update"`
+	LastClaim   string          `json:"last_claim"`
+}
+
+type blockSnapshot struct {
+	Time     string `json:"time"`
+	Expected int64  `json:"expected"`
+	Produced int64  `json:"produced"`
+}
+
+func init() {
+	telegram.OnProducerCommand(renderProducerReport)
+}
+
+// CheckReliability snapshots every active producer's expected and
+// produced blocks and alerts users whose producers fall behind
+func CheckReliability() {
+	users, err := db.GetActiveUsers(telegram.NotifyStop, telegram.AlertStop, telegram.RemindStop)
+	if err != nil {
+		log.Print(err)
+	}
+
+	for _, c := range chain.Chains() {
+		checkReliability(c, users)
+	}
+}
+
+func checkReliability(c *chain.Chain, users []db.User) {
+	all, err := getAllProducers(c.Client.Fresh(), c.System)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	scores := make(map[string]float64)
+	now := time.Now().UTC()
+
+	for _, p := range all.Producers {
+		if p.IsActive != 1 {
+			continue
+		}
+
+		history, err := snapshotBlocks(c, p, now)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+
+		score, _, ok := history.score(reliability_windows[0].Length, now)
+		if ok {
+			scores[p.Owner] = score
+		}
+	}
+
+	for _, user := range users {
+		if user.Settings.Reliability > 0 {
+			alertReliability(c, user, scores)
+		}
+	}
+}
+
+func snapshotBlocks(c *chain.Chain, p producer, now time.Time) (blockHistory, error) {
+	key := reliability_state + p.Owner
+	history := blockHistory{}
+
+	found, err := db.GetState(c.Key, key, &history)
+	if err != nil {
+		return history, err
+	}
+
+	next := blockSnapshot{Time: now.Format(time.RFC3339)}
+
+	if found && len(history.Snapshots) > 0 {
+		// a stale node can show an older claim, only a later one resets,
+		// histories saved before claims were kept go by the counters
+		reset := p.LastClaimTime > history.LastClaim
+		if len(history.LastClaim) == 0 {
+			reset = p.ExpectedProducedBlocks < history.RawExpected
+		}
+
+		expected, ok := counterDelta(history.RawExpected, p.ExpectedProducedBlocks, reset)
+		produced, ok_produced := counterDelta(history.RawUnpaid, p.UnpaidBlocks, reset)
+
+		// counters going down without a claim come from a node
+		// behind the one last read, the snapshot is skipped
+		if !ok || !ok_produced {
+			return history, nil
+		}
+
+		last := history.Snapshots[len(history.Snapshots)-1]
+		next.Expected = last.Expected + expected
+		next.Produced = last.Produced + produced
+	}
+
+	history.RawExpected = p.ExpectedProducedBlocks
+	history.RawUnpaid = p.UnpaidBlocks
+	history.LastUpdate = p.LastExpectedProducedBlocksUpdate
+	history.LastClaim = p.LastClaimTime
+
+	kept := []blockSnapshot{}
+	for _, snapshot := range history.Snapshots {
+		t, err := time.Parse(time.RFC3339, snapshot.Time)
+		if err == nil && now.Sub(t) <= reliability_keep {
+			kept = append(kept, snapshot)
+		}
+	}
+
+	history.Snapshots = append(kept, next)
+	db.SetState(c.Key, key, history)
+
+	return history, nil
+}
+
+// counterDelta is how much a counter grew, counted from zero after a reset.
+// A counter that went down without a reset can't be counted.
+func counterDelta(before int, after int, reset bool) (int64, bool) {
+	if reset {
+		return int64(after), true
+	}
+	if after < before {
+		return 0, false
+	}
+	return int64(after - before), true
+}
+
+// score is the percent of expected blocks produced over the window,
+// or over the history there is when it is shorter, and the time covered
+func (h blockHistory) score(window time.Duration, now time.Time) (float64, time.Duration, bool) {
+	if len(h.Snapshots) < 2 {
+		return 0, 0, false
+	}
+
+	last := h.Snapshots[len(h.Snapshots)-1]
+
+	for _, first := range h.Snapshots {
+		t, err := time.Parse(time.RFC3339, first.Time)
+		if err != nil || now.Sub(t) > window {
+			continue
+		}
+
+		expected := last.Expected - first.Expected
+		if expected <= 0 {
+			return 0, 0, false
+		}
+
+		percent := float64(last.Produced-first.Produced) / float64(expected) * 100
+		if percent > 100 {
+			percent = 100
+		}
+
+		return percent, now.Sub(t), true
+	}
+
+	return 0, 0, false
+}
+
+func alertReliability(c *chain.Chain, user db.User, scores map[string]float64) {
+	key := reliability_alert_state + user.TelegramID
+	alerts := make(map[string]bool)
+
+	_, err := db.GetState(c.Key, key, &alerts)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	threshold := float64(user.Settings.Reliability)
+	changed := false
+
+	for _, account := range chain.AccountsOn(c.Key, user.Accounts) {
+		score, ok := scores[account]
+		if !ok {
+			continue
+		}
+
+		message := "_" + time.Now().Format("Mon Jan _2 15:04 2006 UTC") + "_"
+
+		if !alerts[account] && score < threshold {
+			message += `\n` + "Producer " + accountLabel(c, account) + " produced *" + formatPercent(score) + "* of its expected blocks over the last " + reliability_windows[0].Name + ", your threshold is " + strconv.Itoa(user.Settings.Reliability) + "%."
+			telegram.SendMessage(user, message)
+
+			alerts[account] = true
+			changed = true
+		} else if alerts[account] && score >= threshold+reliability_hysteresis {
+			message += `\n` + "Producer " + accountLabel(c, account) + " is back up to *" + formatPercent(score) + "* of its expected blocks."
+			telegram.SendMessage(user, message)
+
+			delete(alerts, account)
+			changed = true
+		}
+	}
+
+	if changed {
+		db.SetState(c.Key, key, alerts)
+	}
+}
+
+// renderProducerReport is what /producer answers
+func renderProducerReport(c *chain.Chain, name string) (string, bool) {
+	all, err := getAllProducers(c.Client, c.System)
+	if err != nil {
+		log.Print(err)
+		return "", false
+	}
+
+	ranked, votes := rankProducers(all)
+
+	for _, p := range all.Producers {
+		if p.Owner != name {
+			continue
+		}
+
+		message := "Producer " + accountLabel(c, name)
+
+		rank := 0
+		for i, owner := range ranked {
+			if owner == name {
+				rank = i + 1
+			}
+		}
+
+		if rank == 0 {
+			message += `\n` + "Status: *inactive*"
+		} else {
+			message += `\n` + "Rank: *" + strconv.Itoa(rank) + "*"
+			message += `\n` + "Votes: *" + strconv.FormatFloat(votes[name], 'f', 0, 64) + "*"
+		}
+
+		message += `\n` + "Last block: *" + p.LastBlockTime + "*"
+
+		history := blockHistory{}
+
+		_, err = db.GetState(c.Key, reliability_state+name, &history)
+		if err != nil {
+			log.Print(err)
+		}
+
+		message += `\n\n` + "Reliability, produced of expected blocks:"
+		now := time.Now().UTC()
+
+		for _, window := range reliability_windows {
+			score, covered, ok := history.score(window.Length, now)
+
+			if !ok {
+				message += `\n` + window.Name + ": no data yet"
+				continue
+			}
+
+			message += `\n` + window.Name + ": *" + formatPercent(score) + "*"
+
+			// a window is partial until the history covers it
+			if covered < window.Length-time.Hour {
+				message += " (last " + strconv.Itoa(int(covered.Hours())) + "h)"
+			}
+		}
+
+		return message, true
+	}
+
+	return "", false
+}
+
+func formatPercent(percent float64) string {
+	return strconv.FormatFloat(percent, 'f', 2, 64) + "%"
+}
@@ -0,0 +1,138 @@
+package watchman
+
+import (
+	"../chain"
+	"../db"
+	"../telegram"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	// alerting state is kept under resources/<telegram id>/<account>
+	resource_state = "resources/"
+	// usage has to fall this many points below the
+	// threshold before an alert is cleared
+	resource_hysteresis = 5
+)
+
+type accountResources struct {
+	RAMQuota int64         `json:"ram_quota"`
+	RAMUsage int64         `json:"ram_usage"`
+	CPULimit resourceLimit `json:"cpu_limit"`
+	NETLimit resourceLimit `json:"net_limit"`
+}
+
+type resourceLimit struct {
+	Used int64 `json:"used"`
+	Max  int64 `json:"max"`
+}
+
+// resourceAlerts records which resources of an account are over their threshold
+type resourceAlerts map[string]bool
+
+// CheckResources alerts users whose accounts use more RAM, CPU or NET than they allow
+func CheckResources() {
+	users, err := db.GetActiveUsers(telegram.NotifyStop, telegram.AlertStop, telegram.RemindStop)
+	if err != nil {
+		log.Print(err)
+	}
+
+	for _, c := range chain.Chains() {
+		checkResources(c, users)
+	}
+}
+
+func checkResources(c *chain.Chain, users []db.User) {
+	// one lookup per account however many users watch it
+	usage := make(map[string]map[string]int)
+
+	for _, user := range users {
+		for _, account := range chain.AccountsOn(c.Key, user.Accounts) {
+			thresholds, ok := user.Settings.Resources[chain.Scope(c.Key, account)]
+			if !ok || thresholds == (db.Resources{}) {
+				continue
+			}
+
+			percent, ok := usage[account]
+			if !ok {
+				a := accountResources{}
+
+				err := c.Client.GetAccount(account, &a)
+				if err != nil {
+					log.Print(err)
+					continue
+				}
+
+				percent = a.percentUsed()
+				usage[account] = percent
+			}
+
+			checkAccountResources(c, user, account, thresholds, percent)
+		}
+	}
+}
+
+func checkAccountResources(c *chain.Chain, user db.User, account string, thresholds db.Resources, percent map[string]int) {
+	key := resource_state + user.TelegramID + "/" + account
+	alerts := resourceAlerts{}
+
+	_, err := db.GetState(c.Key, key, &alerts)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	limits := map[string]int{"RAM": thresholds.RAM, "CPU": thresholds.CPU, "NET": thresholds.NET}
+	changed := false
+
+	for _, resource := range []string{"RAM", "CPU", "NET"} {
+		threshold := limits[resource]
+		used, known := percent[resource]
+
+		if threshold == 0 || !known {
+			continue
+		}
+
+		message := "_" + time.Now().Format("Mon Jan _2 15:04 2006 UTC") + "_"
+
+		if !alerts[resource] && used >= threshold {
+			message += `\n` + "Account " + accountLabel(c, account) + " is using *" + strconv.Itoa(used) + "%* of its " + resource + ", your threshold is " + strconv.Itoa(threshold) + "%."
+			telegram.SendMessage(user, message)
+
+			alerts[resource] = true
+			changed = true
+		} else if alerts[resource] && used < threshold-resource_hysteresis {
+			message += `\n` + "Account " + accountLabel(c, account) + " " + resource + " usage is back down to *" + strconv.Itoa(used) + "%*."
+			telegram.SendMessage(user, message)
+
+			delete(alerts, resource)
+			changed = true
+		}
+	}
+
+	if changed {
+		db.SetState(c.Key, key, alerts)
+	}
+}
+
+// percentUsed leaves out resources without a limit, such as
+// those of system accounts which report -1
+func (a accountResources) percentUsed() map[string]int {
+	percent := make(map[string]int)
+
+	if a.RAMQuota > 0 {
+		percent["RAM"] = int(a.RAMUsage * 100 / a.RAMQuota)
+	}
+
+	if a.CPULimit.Max > 0 {
+		percent["CPU"] = int(a.CPULimit.Used * 100 / a.CPULimit.Max)
+	}
+
+	if a.NETLimit.Max > 0 {
+		percent["NET"] = int(a.NETLimit.Used * 100 / a.NETLimit.Max)
+	}
+
+	return percent
+}
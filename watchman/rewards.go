@@ -0,0 +1,118 @@
+package watchman
+
+import (
+	"../chain"
+	"../db"
+	"../telegram"
+	"log"
+	"strconv"
+	"time"
+)
+
+// reminder state is kept under rewards/<telegram id>/<producer>
+const reward_state = "rewards/"
+
+// rewards can be claimed once a day
+const claim_interval = time.Hour * 24
+
+// rewardReminder is the claim a user was last reminded of and when
+type rewardReminder struct {
+	LastClaimTime string `json:"last_claim_time"`
+	Reminded      string `json:"reminded"`
+}
+
+// sendRewardReminders nudges producer owners whose rewards can be claimed,
+// or have waited for the days they picked, every so many days until claimed
+func sendRewardReminders(c *chain.Chain, users []db.User) {
+	var all producers
+	var err error
+	loaded := false
+
+	for _, user := range users {
+		wait := rewardWait(user.Settings.Rewards.Setting)
+		if wait == 0 {
+			continue
+		}
+
+		accounts := chain.AccountsOn(c.Key, user.Accounts)
+		if len(accounts) == 0 {
+			continue
+		}
+
+		// read once, and only when someone wants reminders on this chain,
+		// from a node that is not behind on claims
+		if !loaded {
+			all, err = getAllProducers(c.Client.Fresh(), c.System)
+			if err != nil {
+				log.Print(err)
+				return
+			}
+			loaded = true
+		}
+
+		for _, p := range all.Producers {
+			if stringInSlice(p.Owner, accounts) {
+				remindRewards(c, user, p, wait)
+			}
+		}
+	}
+}
+
+func remindRewards(c *chain.Chain, user db.User, p producer, wait time.Duration) {
+	if p.UnpaidBlocks == 0 && p.PendingPervoteReward == 0 {
+		return
+	}
+
+	last_claim, err := time.Parse("2006-01-02T15:04:05.9", p.LastClaimTime)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	unclaimed := time.Since(last_claim)
+	if unclaimed < wait {
+		return
+	}
+
+	key := reward_state + user.TelegramID + "/" + p.Owner
+	state := rewardReminder{}
+
+	_, err = db.GetState(c.Key, key, &state)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	// a claim starts over, otherwise remind again after another wait
+	reminded, err := time.Parse(time.RFC3339, state.Reminded)
+	if state.LastClaimTime == p.LastClaimTime && err == nil && time.Since(reminded) < wait {
+		return
+	}
+
+	days := int(unclaimed.Hours() / 24)
+
+	message := "_" + time.Now().Format("Mon Jan _2 15:04 2006 UTC") + "_"
+	message += `\n` + "Producer " + accountLabel(c, p.Owner) + " has rewards to claim."
+	message += `\n\n` + "Unpaid blocks: *" + strconv.Itoa(p.UnpaidBlocks) + "*"
+	message += `\n` + "Pending vote reward: *" + c.FormatStake(int64(p.PendingPervoteReward)) + "*"
+	message += `\n` + "Last claimed: *" + last_claim.Format("Mon Jan _2 15:04 2006 UTC") + "* (" + strconv.Itoa(days) + " days ago)"
+
+	telegram.SendMessage(user, message)
+
+	state = rewardReminder{LastClaimTime: p.LastClaimTime, Reminded: time.Now().UTC().Format(time.RFC3339)}
+	db.SetState(c.Key, key, state)
+}
+
+// rewardWait is how long rewards go unclaimed before a reminder, 0 for none
+func rewardWait(setting string) time.Duration {
+	switch setting {
+	case telegram.RewardsClaimable:
+		return claim_interval
+	case telegram.RewardsThreeDays:
+		return claim_interval * 3
+	case telegram.RewardsWeek:
+		return claim_interval * 7
+	}
+
+	return 0
+}
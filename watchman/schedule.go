@@ -0,0 +1,118 @@
+package watchman
+
+import (
+	"github.com/joho/godotenv"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Job is one of watchman's checks with its own schedule.
+// Interval and Jitter are read from WATCH_<NAME>_INTERVAL
+// and WATCH_<NAME>_JITTER, in seconds.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	// longest random delay before a run, spreads chain load
+	Jitter  time.Duration
+	run     func()
+	running int32
+}
+
+// defaults in seconds: interval, jitter
+var job_defaults = map[string][2]int{
+	"NOTIFY":      {1, 0},
+	"ALERT":       {60, 10},
+	"REMIND":      {3600, 300},
+	"RESOURCE":    {300, 30},
+	"BALANCE":     {300, 30},
+	"VOTERS":      {600, 60},
+	"RELIABILITY": {3600, 300},
+	"SWAPS":       {30, 5},
+	"CACHE":       {600, 0},
+}
+
+// Jobs lists watchman's checks with their configured schedules
+func Jobs() []*Job {
+	config := scheduleConfig()
+	rand.Seed(time.Now().UnixNano())
+
+	jobs := []*Job{
+		&Job{Name: "NOTIFY", run: Notify},
+		&Job{Name: "ALERT", run: Alert},
+		&Job{Name: "REMIND", run: Remind},
+		&Job{Name: "RESOURCE", run: CheckResources},
+		&Job{Name: "BALANCE", run: CheckBalances},
+		&Job{Name: "VOTERS", run: CheckVoters},
+		&Job{Name: "RELIABILITY", run: CheckReliability},
+		&Job{Name: "SWAPS", run: FollowSwaps},
+		&Job{Name: "CACHE", run: LogCacheStats},
+	}
+
+	for _, j := range jobs {
+		j.Interval = time.Duration(config[j.Name][0]) * time.Second
+		j.Jitter = time.Duration(config[j.Name][1]) * time.Second
+	}
+
+	return jobs
+}
+
+// Run runs the job unless its previous run is still going,
+// a slow tick is skipped rather than queued behind
+func (j *Job) Run() {
+	if !atomic.CompareAndSwapInt32(&j.running, 0, 1) {
+		log.Print(j.Name + ": previous run still in progress, skipping")
+		return
+	}
+	defer atomic.StoreInt32(&j.running, 0)
+
+	if j.Jitter > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(j.Jitter))))
+	}
+
+	j.run()
+}
+
+func scheduleConfig() map[string][2]int {
+	err := godotenv.Load("/root/rem-alert-api/.env")
+	if err != nil {
+		log.Print("Error loading .env file")
+	}
+
+	conf := make(map[string][2]int)
+
+	for name, defaults := range job_defaults {
+		conf[name] = [2]int{
+			envSeconds("WATCH_"+name+"_INTERVAL", defaults[0]),
+			envSeconds("WATCH_"+name+"_JITTER", defaults[1]),
+		}
+	}
+
+	// gocron counts in whole seconds of at least one
+	for name, c := range conf {
+		if c[0] < 1 {
+			c[0] = 1
+			conf[name] = c
+		}
+	}
+
+	return conf
+}
+
+func envSeconds(key string, fallback int) int {
+	value := os.Getenv(key)
+	if len(value) == 0 {
+		return fallback
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil {
+		log.Print(key + ": " + err.Error())
+		return fallback
+	}
+
+	return seconds
+}
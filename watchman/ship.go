@@ -0,0 +1,159 @@
+package watchman
+
+import (
+	"../chain"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// blocks nodeos may send ahead of our acknowledgements
+const ship_max_in_flight = 10
+
+// runShip follows blocks from the notification cursor
+// and hands matching action traces to the notification path
+func runShip(c *chain.Chain) error {
+	cursor, started, err := readCursor(c, notification_cursor)
+	if err != nil {
+		return err
+	}
+
+	s, err := chain.DialShip(c.Ship)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	start := uint32(cursor.BlockNum)
+
+	// a cursor just set at the head has nothing before it to read
+	if started {
+		start++
+	}
+
+	err = s.RequestBlocks(start, ship_max_in_flight)
+	if err != nil {
+		return err
+	}
+
+	atomic.StoreInt32(streaming[c.Key], 1)
+
+	names := flatten(notification_actions_to_watch)
+	batch := []action{}
+	flushed := time.Now()
+
+	for {
+		b, err := s.ReadBlock()
+		if err != nil {
+			return err
+		}
+
+		batch = append(batch, shipActions(c, b, names)...)
+
+		if len(batch) > 0 && time.Since(flushed) >= stream_flush_interval {
+			processStreamed(c, batch)
+			batch = []action{}
+			flushed = time.Now()
+		}
+	}
+}
+
+// shipActions converts a block's executed traces into the same
+// action documents hyperion returns, notifications to other
+// receivers are folded into the action's notified accounts
+// so every action appears once
+func shipActions(c *chain.Chain, b chain.ShipBlock, names []string) []action {
+	output := []action{}
+
+	for _, trace := range b.Traces {
+		if trace.Status != chain.TraceExecuted {
+			continue
+		}
+
+		// an action and its notifications share account, name and data
+		receivers := make(map[string][]string)
+
+		for _, at := range trace.ActionTraces {
+			if at.HasReceipt {
+				key := at.Account + " " + at.Name + " " + string(at.Data)
+				receivers[key] = append(receivers[key], at.Receiver)
+			}
+		}
+
+		for _, at := range trace.ActionTraces {
+			if !at.HasReceipt || at.Receiver != at.Account || !stringInSlice(at.Name, names) {
+				continue
+			}
+
+			a := action{}
+			a.Timestamp = b.Timestamp.Format("2006-01-02T15:04:05.000")
+			a.BlockNum = json.Number(strconv.FormatUint(uint64(b.Num), 10))
+			a.TrxID = trace.ID
+			a.GlobalSequence = json.Number(strconv.FormatUint(at.GlobalSequence, 10))
+			a.Act.Account = at.Account
+			a.Act.Name = at.Name
+			a.Act.Data = decodeActionData(c, at.Account, at.Name, at.Data)
+			a.Notified = receivers[at.Account+" "+at.Name+" "+string(at.Data)]
+
+			for _, permission := range at.Authorization {
+				a.Act.Authorizations = append(a.Act.Authorizations, authorization{Actor: permission.Actor, Permission: permission.Permission})
+			}
+
+			output = append(output, a)
+		}
+	}
+
+	return output
+}
+
+// decodeActionData decodes an action's binary data with its contract's abi,
+// when that fails the actions parseData knows are decoded by hand
+// and the rest is kept as hex
+func decodeActionData(c *chain.Chain, account string, action_name string, data []byte) map[string]interface{} {
+	if abi, err := getAbi(c, account); err == nil {
+		decoded, err := abi.DecodeAction(action_name, data)
+		if err == nil {
+			return decoded
+		}
+
+		log.Print(account + " " + action_name + ": " + err.Error())
+	}
+
+	r := chain.NewReader(data)
+	output := make(map[string]interface{})
+
+	switch action_name {
+	case transfer_s:
+		output["from"] = r.Name()
+		output["to"] = r.Name()
+		output["quantity"] = r.Asset()
+		output["memo"] = r.Text()
+	case linkauth_s:
+		output["account"] = r.Name()
+		output["code"] = r.Name()
+		output["type"] = r.Name()
+		output["requirement"] = r.Name()
+	case unlinkauth_s:
+		output["account"] = r.Name()
+		output["code"] = r.Name()
+		output["type"] = r.Name()
+	case updateauth_s:
+		output["account"] = r.Name()
+		output["permission"] = r.Name()
+		output["parent"] = r.Name()
+	case deleteauth_s:
+		output["account"] = r.Name()
+		output["permission"] = r.Name()
+	case unregprod_s:
+		output["producer"] = r.Name()
+	}
+
+	if len(output) == 0 || r.Err() != nil {
+		return map[string]interface{}{"hex": hex.EncodeToString(data)}
+	}
+
+	return output
+}
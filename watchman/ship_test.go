@@ -0,0 +1,122 @@
+package watchman
+
+import (
+	"../chain"
+	"encoding/hex"
+	"github.com/gorilla/websocket"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serveShipFrames streams the recorded get_blocks_result frames
+// once blocks are requested
+func serveShipFrames(t *testing.T, path string) *httptest.Server {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames := [][]byte{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		frame, err := hex.DecodeString(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, frame)
+	}
+
+	upgrader := websocket.Upgrader{}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"version":"eosio::abi/1.1"}`))
+
+		for {
+			_, request, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			// only the blocks request is answered, acks are read and dropped
+			if chain.NewReader(request).Varuint32() == 1 {
+				for _, frame := range frames {
+					conn.WriteMessage(websocket.BinaryMessage, frame)
+				}
+			}
+		}
+	}))
+}
+
+// a recorded block converts to hyperion's action documents, the
+// notifications fold into notified and the failed transaction is left out
+func TestShipActionsFromRecordedBlock(t *testing.T) {
+	c, _, _ := useFake(t)
+
+	server := serveShipFrames(t, "../chain/testdata/ship_blocks.hex")
+	defer server.Close()
+
+	s, err := chain.DialShip(strings.Replace(server.URL, "http://", "ws://", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.RequestBlocks(120, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := s.ReadBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actions := shipActions(c, b, flatten(notification_actions_to_watch))
+	if len(actions) != 2 {
+		t.Fatalf("read %d actions, want 2", len(actions))
+	}
+
+	want := []struct {
+		sequence string
+		from     string
+		to       string
+		quantity string
+		memo     string
+		notified string
+	}{
+		{"11", "alice", "bob", "1.2345 REM", "rent", "rem.token,alice,bob"},
+		{"14", "bob", "carol", "0.0100 REM", "fee", "rem.token,carol"},
+	}
+
+	for i, a := range actions {
+		if string(a.GlobalSequence) != want[i].sequence || string(a.BlockNum) != "120" || a.TrxID != strings.Repeat("aa", 32) || a.Timestamp != "2026-10-17T10:00:05.000" {
+			t.Errorf("action %d: %s in block %s trx %s at %s", i, a.GlobalSequence, a.BlockNum, a.TrxID, a.Timestamp)
+		}
+
+		if a.Act.Account != "rem.token" || a.Act.Name != transfer_s || len(a.Act.Authorizations) != 1 || a.Act.Authorizations[0].Actor != want[i].from {
+			t.Errorf("action %d: %+v", i, a.Act)
+		}
+
+		data := a.Act.Data
+		if data["from"] != want[i].from || data["to"] != want[i].to || data["quantity"] != want[i].quantity || data["memo"] != want[i].memo {
+			t.Errorf("action %d data %v", i, data)
+		}
+
+		if notified := strings.Join(a.Notified, ","); notified != want[i].notified {
+			t.Errorf("action %d notified %s, want %s", i, notified, want[i].notified)
+		}
+	}
+}
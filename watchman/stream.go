@@ -0,0 +1,290 @@
+package watchman
+
+import (
+	"../chain"
+	"../db"
+	"../telegram"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// streamed actions are delivered in batches
+	stream_flush_interval = time.Second
+	// how often the watched accounts are compared with the subscriptions
+	stream_resubscribe_interval = time.Minute
+	stream_max_backoff          = time.Minute
+)
+
+// streaming holds a flag per chain that is 1 while its stream
+// is subscribed, the poller leaves executed actions to it meanwhile
+var streaming = make(map[string]*int32)
+
+func init() {
+	for _, c := range chain.Chains() {
+		streaming[c.Key] = new(int32)
+	}
+}
+
+func isStreaming(c *chain.Chain) bool {
+	return atomic.LoadInt32(streaming[c.Key]) == 1
+}
+
+// Stream feeds every chain's notifications from a push source, nodeos'
+// state history when one is configured, hyperion's action stream otherwise.
+// It reconnects from the notification cursor whenever the source drops
+// and polling takes over for as long as it is down.
+func Stream() {
+	for _, c := range chain.Chains() {
+		c := c
+
+		if len(c.Ship) > 0 {
+			go follow(c, func() error { return runShip(c) })
+		} else if len(c.Stream) > 0 {
+			go follow(c, func() error { return runStream(c) })
+		}
+	}
+}
+
+func follow(c *chain.Chain, run func() error) {
+	backoff := time.Second
+
+	for {
+		started := time.Now()
+		err := run()
+
+		atomic.StoreInt32(streaming[c.Key], 0)
+		log.Print("stream " + c.Key + ": " + err.Error() + ", polling until reconnected")
+
+		// a stream that held for a while starts over with a short wait
+		if time.Since(started) > stream_max_backoff {
+			backoff = time.Second
+		}
+
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > stream_max_backoff {
+			backoff = stream_max_backoff
+		}
+	}
+}
+
+func runStream(c *chain.Chain) error {
+	users, err := db.GetActiveUsers(telegram.NotifyStop, telegram.AlertStop, telegram.RemindStop)
+	if err != nil {
+		return err
+	}
+
+	accounts := streamedAccounts(c, users)
+	if len(accounts) == 0 {
+		return errors.New("no accounts to stream")
+	}
+
+	cursor, _, err := readCursor(c, notification_cursor)
+	if err != nil {
+		return err
+	}
+
+	s, err := chain.DialStream(c.Stream)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// one request per account replays from the cursor's block the actions
+	// it authorizes, receives or is notified of, the watched names are
+	// picked here. What was already delivered is dropped by sequence.
+	for _, account := range accounts {
+		err = s.Subscribe(chain.StreamRequest{
+			Contract:  "*",
+			Action:    "*",
+			StartFrom: cursor.BlockNum,
+			Filters: []chain.StreamFilter{
+				chain.StreamFilter{Field: "act.authorization.actor", Value: account},
+				chain.StreamFilter{Field: "notified", Value: account},
+			},
+			FilterOp: "or",
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	messages := make(chan chain.StreamMessage, 1000)
+	errs := make(chan error, 1)
+	quit := make(chan struct{})
+	defer close(quit)
+
+	go func() {
+		for {
+			m, err := s.Read()
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			select {
+			case messages <- m:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	atomic.StoreInt32(streaming[c.Key], 1)
+	log.Print("stream " + c.Key + ": subscribed to " + c.Stream + " from block " + strconv.FormatUint(cursor.BlockNum, 10))
+
+	flush := time.NewTicker(stream_flush_interval)
+	defer flush.Stop()
+
+	resubscribe := time.NewTicker(stream_resubscribe_interval)
+	defer resubscribe.Stop()
+
+	names := flatten(notification_actions_to_watch)
+	batch := newStreamBatch(len(accounts))
+
+	for {
+		select {
+		case m := <-messages:
+			if m.Type == chain.StreamReplayed {
+				if batch.replayed() {
+					log.Print("stream " + c.Key + ": replay done")
+				}
+				continue
+			}
+
+			a := action{}
+
+			err = json.Unmarshal(m.Message, &a)
+			if err != nil {
+				log.Print(err)
+				continue
+			}
+
+			if stringInSlice(a.Act.Name, names) {
+				batch.add(a)
+			}
+
+		case err = <-errs:
+			return err
+
+		case <-flush.C:
+			if batch.ready() {
+				processStreamed(c, batch.take())
+			}
+
+		case <-resubscribe.C:
+			users, err = db.GetActiveUsers(telegram.NotifyStop, telegram.AlertStop, telegram.RemindStop)
+			if err != nil {
+				log.Print(err)
+				continue
+			}
+
+			if strings.Join(streamedAccounts(c, users), ",") != strings.Join(accounts, ",") {
+				// a replay cut short is read again from the cursor
+				if batch.ready() {
+					processStreamed(c, batch.take())
+				}
+
+				return errors.New("watched accounts changed, resubscribing")
+			}
+		}
+	}
+}
+
+// streamBatch collects streamed actions until they are delivered.
+// Each request replays on its own, a batch delivered before every
+// replay is done would move the cursor past actions still to come,
+// so the live tail is held back until then.
+type streamBatch struct {
+	replaying int
+	actions   []action
+}
+
+func newStreamBatch(requests int) *streamBatch {
+	return &streamBatch{replaying: requests}
+}
+
+func (b *streamBatch) add(a action) {
+	b.actions = append(b.actions, a)
+}
+
+// replayed counts a request's replay as done,
+// it tells whether that was the last one
+func (b *streamBatch) replayed() bool {
+	if b.replaying == 0 {
+		return false
+	}
+
+	b.replaying--
+
+	return b.replaying == 0
+}
+
+// ready tells whether there is something to deliver and every replay is done
+func (b *streamBatch) ready() bool {
+	return b.replaying == 0 && len(b.actions) > 0
+}
+
+func (b *streamBatch) take() []action {
+	taken := b.actions
+	b.actions = nil
+
+	return taken
+}
+
+// processStreamed runs a batch through the same path the poller uses
+func processStreamed(c *chain.Chain, batch []action) {
+	notify_mu.Lock()
+	defer notify_mu.Unlock()
+
+	cursor, err := db.GetCursor(c.Key, notification_cursor)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	users, err := db.GetActiveUsers(telegram.NotifyStop, telegram.AlertStop, telegram.RemindStop)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	new_actions, next_cursor := pastCursor(cursor, batch)
+
+	if len(new_actions) > 0 {
+		deliverNotifications(c, users, new_actions)
+	}
+
+	if next_cursor != cursor {
+		db.UpdateCursor(next_cursor)
+	}
+}
+
+// streamedAccounts lists, sorted, every account on the chain a user wants notifications for
+func streamedAccounts(c *chain.Chain, users []db.User) []string {
+	accounts := []string{}
+
+	for _, user := range users {
+		if user.Settings.Notification.Setting == telegram.NotifyStop {
+			continue
+		}
+
+		for _, account := range chain.AccountsOn(c.Key, user.Accounts) {
+			if !stringInSlice(account, accounts) {
+				accounts = append(accounts, account)
+			}
+		}
+	}
+
+	sort.Strings(accounts)
+
+	return accounts
+}
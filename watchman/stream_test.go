@@ -0,0 +1,77 @@
+package watchman
+
+import (
+	"../db"
+	"encoding/json"
+	"testing"
+)
+
+func streamedAction(seq string) action {
+	return action{GlobalSequence: json.Number(seq), BlockNum: json.Number(seq), Timestamp: "2026-10-17T10:00:05.000"}
+}
+
+// two requests replay side by side while the first one already streams
+// live, nothing may be delivered until both replays are done or the
+// cursor moves past the second one's older actions
+func TestStreamReplayInterleavedWithLive(t *testing.T) {
+	cursor := db.Cursor{Sequence: 4, Timestamp: "2026-10-17T10:00:00.000"}
+	b := newStreamBatch(2)
+
+	b.add(streamedAction("5"))
+	b.add(streamedAction("7"))
+
+	if b.replayed() {
+		t.Fatal("replay done after the first of two requests")
+	}
+
+	b.add(streamedAction("30"))
+
+	if b.ready() {
+		t.Fatal("live tail ready while a request is still replaying")
+	}
+
+	b.add(streamedAction("8"))
+
+	if !b.replayed() {
+		t.Fatal("replay not done after both requests")
+	}
+
+	// the same action streamed for two accounts
+	b.add(streamedAction("30"))
+	b.add(streamedAction("31"))
+
+	if !b.ready() {
+		t.Fatal("batch not ready after the replays")
+	}
+
+	delivered, next := pastCursor(cursor, b.take())
+
+	got := []string{}
+	for _, a := range delivered {
+		got = append(got, string(a.GlobalSequence))
+	}
+
+	want := []string{"5", "7", "8", "30", "31"}
+	if len(got) != len(want) {
+		t.Fatalf("delivered %v, want %v", got, want)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("delivered %v, want %v", got, want)
+		}
+	}
+
+	if next.Sequence != 31 {
+		t.Errorf("cursor at %d, want 31", next.Sequence)
+	}
+
+	if b.ready() {
+		t.Error("batch still ready after it was taken")
+	}
+
+	// later acknowledgements, such as of a resubscription, change nothing
+	if b.replayed() {
+		t.Error("replay done again")
+	}
+}
@@ -0,0 +1,292 @@
+package watchman
+
+import (
+	"../chain"
+	"../db"
+	"../telegram"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	swap_cursor = "swaps"
+	// each followed swap is kept under swaps/<key>
+	swap_state = "swaps/"
+	// how many of the newest swaps are searched for a swap's txid
+	swap_lookup = 100
+
+	swap_canceled  = -1
+	swap_initiated = 0
+	swap_issued    = 1
+	swap_finished  = 2
+)
+
+// actions that name who started a swap and who receives it
+var swap_actions_to_watch = []string{
+	"init", "finish", "finishnewacc",
+}
+
+// swapProgress is what was last reported of a followed swap,
+// and the accounts that started or received it. Settled swaps
+// are kept while followed so they are not reported again.
+type swapProgress struct {
+	Key       string   `json:"key"`
+	TxID      string   `json:"txid"`
+	Status    int      `json:"status"`
+	Approvals []string `json:"approvals"`
+	Accounts  []string `json:"accounts"`
+	Settled   bool     `json:"settled"`
+}
+
+type swapData struct {
+	Rampayer string `json:"rampayer"`
+	Receiver string `json:"receiver"`
+	TxID     string `json:"txid"`
+}
+
+// FollowSwaps reports the progress of the swaps users follow
+func FollowSwaps() {
+	users, err := db.GetActiveUsers(telegram.NotifyStop, telegram.AlertStop, telegram.RemindStop)
+	// without the users every followed swap would look unfollowed
+	// and be dropped, and accounts' swaps read past unmatched
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	for _, c := range chain.Chains() {
+		if len(c.Swap) > 0 {
+			followSwaps(c, users)
+		}
+	}
+}
+
+func followSwaps(c *chain.Chain, users []db.User) {
+	keys := []string{}
+	accounts := []string{}
+
+	for _, user := range users {
+		for _, watch := range user.Settings.Swaps {
+			if watch.Chain != c.Key {
+				continue
+			}
+
+			if len(watch.Key) > 0 && !stringInSlice(watch.Key, keys) {
+				keys = append(keys, watch.Key)
+			} else if len(watch.Account) > 0 && !stringInSlice(watch.Account, accounts) {
+				accounts = append(accounts, watch.Account)
+			}
+		}
+	}
+
+	tracked, err := db.GetStates(c.Key, swap_state)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	progress := make(map[string]swapProgress)
+
+	for _, raw := range tracked {
+		p := swapProgress{}
+
+		err = json.Unmarshal(raw, &p)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+
+		progress[p.Key] = p
+	}
+
+	// swaps followed by key are tracked from their current state
+	for _, key := range keys {
+		if _, ok := progress[key]; !ok {
+			progress[key] = swapProgress{Key: key, Status: swap_initiated}
+		}
+	}
+
+	// read even without accounts to follow, so the cursor keeps up
+	for _, p := range accountSwaps(c, accounts) {
+		if existing, ok := progress[p.Key]; ok {
+			for _, account := range p.Accounts {
+				if !stringInSlice(account, existing.Accounts) {
+					existing.Accounts = append(existing.Accounts, account)
+				}
+			}
+			p = existing
+		}
+
+		progress[p.Key] = p
+	}
+
+	for key, p := range progress {
+		if !p.followed(c, users) {
+			db.DeleteState(c.Key, swap_state+key)
+			continue
+		}
+
+		if !p.Settled {
+			trackSwap(c, users, p)
+		}
+	}
+}
+
+// accountSwaps reads the swap contract's actions since the last run and
+// the swaps started or received by the accounts, with the txid's key looked up
+func accountSwaps(c *chain.Chain, accounts []string) []swapProgress {
+	found := []swapProgress{}
+
+	cursor, started, err := readCursor(c, swap_cursor)
+	if err != nil {
+		log.Print(err)
+		return found
+	}
+
+	if started {
+		return found
+	}
+
+	a, err := getActions(c.Client, chain.ActionQuery{After: cursorTime(cursor), Contracts: c.Swap, Names: strings.Join(swap_actions_to_watch, ",")})
+	if err != nil {
+		log.Print(err)
+		return found
+	}
+
+	new_actions, next_cursor := pastCursor(cursor, a.Actions)
+	by_txid := make(map[string][]string)
+
+	for _, action := range new_actions {
+		d := swapData{}
+
+		data, _ := json.Marshal(action.Act.Data)
+		json.Unmarshal(data, &d)
+
+		for _, account := range []string{d.Rampayer, d.Receiver} {
+			if stringInSlice(account, accounts) && !stringInSlice(account, by_txid[d.TxID]) {
+				by_txid[d.TxID] = append(by_txid[d.TxID], account)
+			}
+		}
+	}
+
+	if len(by_txid) > 0 {
+		q := chain.TableQuery{Code: c.Swap, Scope: c.Swap, Table: "swaps", Reverse: true, Limit: table_page_size}
+		recent := swaps{}
+
+		err = chain.ReadTable(c.Client, q, swap_lookup, &recent)
+		if err != nil {
+			// the actions are read again next run
+			log.Print(err)
+			return found
+		}
+
+		for _, s := range recent.Swaps {
+			if matched, ok := by_txid[s.TxID]; ok {
+				found = append(found, swapProgress{Key: strconv.Itoa(s.Key), TxID: s.TxID, Status: swap_initiated, Accounts: matched})
+			}
+		}
+	}
+
+	if next_cursor != cursor {
+		db.UpdateCursor(next_cursor)
+	}
+
+	return found
+}
+
+func trackSwap(c *chain.Chain, users []db.User, p swapProgress) {
+	key := swap_state + p.Key
+
+	q := chain.TableQuery{Code: c.Swap, Scope: c.Swap, Table: "swaps", LowerBound: p.Key, Limit: 1}
+	rows := swaps{}
+
+	err := c.Client.GetTableRows(q, &rows)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	if len(rows.Swaps) == 0 || strconv.Itoa(rows.Swaps[0].Key) != p.Key {
+		sendSwapMessage(c, users, p, "Swap *"+p.Key+"*"+chainLabel(c)+" is no longer in the swap table.")
+
+		p.Settled = true
+		db.SetState(c.Key, key, p)
+		return
+	}
+
+	s := rows.Swaps[0]
+
+	approved := []string{}
+	for _, producer := range s.ProvidedApprovals {
+		if !stringInSlice(producer, p.Approvals) {
+			approved = append(approved, producer)
+		}
+	}
+
+	if len(approved) > 0 || s.Status != p.Status {
+		message := "_" + time.Now().Format("Mon Jan _2 15:04 2006 UTC") + "_"
+		message += `\n` + "Swap *" + p.Key + "*" + chainLabel(c) + " is *" + swapStatus(s.Status) + "*."
+		message += `\n\n` + "Approvals: *" + strconv.Itoa(len(s.ProvidedApprovals)) + "*"
+
+		if len(approved) > 0 {
+			message += `\n` + "New: " + strings.Join(approved, ", ")
+		}
+
+		sendSwapMessage(c, users, p, message)
+	}
+
+	p.TxID = s.TxID
+	p.Status = s.Status
+	p.Approvals = s.ProvidedApprovals
+	p.Settled = s.Status == swap_finished || s.Status == swap_canceled
+
+	db.SetState(c.Key, key, p)
+}
+
+// followed tells whether any user still follows the swap by key or account
+func (p swapProgress) followed(c *chain.Chain, users []db.User) bool {
+	for _, user := range users {
+		if p.followedBy(c, user) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p swapProgress) followedBy(c *chain.Chain, user db.User) bool {
+	for _, watch := range user.Settings.Swaps {
+		if watch.Chain != c.Key {
+			continue
+		}
+
+		if watch.Key == p.Key || (len(watch.Account) > 0 && stringInSlice(watch.Account, p.Accounts)) {
+			return true
+		}
+	}
+	return false
+}
+
+func sendSwapMessage(c *chain.Chain, users []db.User, p swapProgress, message string) {
+	for _, user := range users {
+		if p.followedBy(c, user) {
+			telegram.SendMessage(user, message)
+		}
+	}
+}
+
+func swapStatus(status int) string {
+	switch status {
+	case swap_canceled:
+		return "canceled"
+	case swap_initiated:
+		return "initiated"
+	case swap_issued:
+		return "issued"
+	case swap_finished:
+		return "finished"
+	}
+	return strconv.Itoa(status)
+}
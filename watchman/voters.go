@@ -0,0 +1,287 @@
+package watchman
+
+import (
+	"../chain"
+	"../db"
+	"../telegram"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// each producer's voters are kept under voters/<producer>
+	voter_state = "voters/"
+	// longest list of voters a message names
+	voter_list_max = 20
+)
+
+// voterState is a producer's voters with their stake, and what it
+// looked like at the start of the day for the daily digest
+type voterState struct {
+	Voters    map[string]int64 `json:"voters"`
+	Votes     float64          `json:"votes"`
+	Date      string           `json:"date"`
+	DayVoters int              `json:"day_voters"`
+	DayStaked int64            `json:"day_staked"`
+	DayVotes  float64          `json:"day_votes"`
+	Gained    []string         `json:"gained"`
+	Lost      []string         `json:"lost"`
+}
+
+// CheckVoters tells producer owners which accounts started or stopped
+// voting for them, and sends the day's change in a digest
+func CheckVoters() {
+	users, err := db.GetActiveUsers(telegram.NotifyStop, telegram.AlertStop, telegram.RemindStop)
+	if err != nil {
+		log.Print(err)
+	}
+
+	for _, c := range chain.Chains() {
+		checkVoters(c, users)
+	}
+}
+
+func checkVoters(c *chain.Chain, users []db.User) {
+	watched := []string{}
+
+	for _, user := range users {
+		if !wantsVoters(user) {
+			continue
+		}
+
+		for _, account := range chain.AccountsOn(c.Key, user.Accounts) {
+			if !stringInSlice(account, watched) {
+				watched = append(watched, account)
+			}
+		}
+	}
+
+	if len(watched) == 0 {
+		return
+	}
+
+	q := chain.TableQuery{Code: c.System, Scope: c.System, Table: "voters", Limit: table_page_size}
+	all := voters{}
+
+	// compared with the saved snapshot, a node behind would report
+	// voters as lost and gained again
+	err := chain.ReadTable(c.Client.Fresh(), q, 0, &all)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	// votes cast through a proxy only name the proxy and are left out
+	current := make(map[string]map[string]int64)
+	for _, v := range all.Voters {
+		staked, _ := strconv.ParseInt(string(v.Staked), 10, 64)
+
+		for _, producer := range v.Producers {
+			if !stringInSlice(producer, watched) {
+				continue
+			}
+
+			if current[producer] == nil {
+				current[producer] = make(map[string]int64)
+			}
+
+			current[producer][v.Owner] = staked
+		}
+	}
+
+	votes, err := producerVotes(c)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	for _, producer := range watched {
+		_, registered := votes[producer]
+		if !registered {
+			continue
+		}
+
+		checkProducerVoters(c, users, producer, current[producer], votes[producer])
+	}
+}
+
+func checkProducerVoters(c *chain.Chain, users []db.User, producer string, current map[string]int64, votes float64) {
+	key := voter_state + producer
+	state := voterState{}
+
+	found, err := db.GetState(c.Key, key, &state)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	if current == nil {
+		current = make(map[string]int64)
+	}
+
+	today := time.Now().UTC().Format("2006-01-02")
+
+	// the first check only takes a snapshot
+	if !found {
+		state = voterState{Voters: current, Votes: votes, Date: today}
+		state.startDay()
+
+		db.SetState(c.Key, key, state)
+		return
+	}
+
+	gained := []string{}
+	lost := []string{}
+
+	for voter := range current {
+		if _, ok := state.Voters[voter]; !ok {
+			gained = append(gained, voter)
+		}
+	}
+
+	for voter := range state.Voters {
+		if _, ok := current[voter]; !ok {
+			lost = append(lost, voter)
+		}
+	}
+
+	sort.Strings(gained)
+	sort.Strings(lost)
+
+	if len(gained) > 0 || len(lost) > 0 {
+		message := "_" + time.Now().Format("Mon Jan _2 15:04 2006 UTC") + "_"
+		message += `\n` + "Producer " + accountLabel(c, producer) + " has voter changes."
+
+		if len(gained) > 0 {
+			message += `\n\n` + "Started voting: *" + strconv.Itoa(len(gained)) + "*" + listVoters(c, gained, current)
+		}
+
+		if len(lost) > 0 {
+			message += `\n\n` + "Stopped voting: *" + strconv.Itoa(len(lost)) + "*" + listVoters(c, lost, state.Voters)
+		}
+
+		sendVoterMessage(c, users, producer, message, telegram.VotersChanges)
+	}
+
+	state.Gained = append(state.Gained, gained...)
+	state.Lost = append(state.Lost, lost...)
+	state.Voters = current
+	state.Votes = votes
+
+	if state.Date != today {
+		sendVoterMessage(c, users, producer, renderVoterDigest(c, producer, state), telegram.VotersDigest)
+
+		state.Date = today
+		state.startDay()
+	}
+
+	db.SetState(c.Key, key, state)
+}
+
+// startDay makes the current voters the ones the next digest compares to
+func (s *voterState) startDay() {
+	s.DayVoters = len(s.Voters)
+	s.DayStaked = s.staked()
+	s.DayVotes = s.Votes
+	s.Gained = []string{}
+	s.Lost = []string{}
+}
+
+func (s voterState) staked() int64 {
+	var total int64
+	for _, staked := range s.Voters {
+		total += staked
+	}
+	return total
+}
+
+func renderVoterDigest(c *chain.Chain, producer string, state voterState) string {
+	message := "_" + time.Now().Format("Mon Jan _2 15:04 2006 UTC") + "_"
+	message += `\n` + "Daily voter digest for " + accountLabel(c, producer) + " on " + state.Date + "."
+
+	message += `\n\n` + "Voters: *" + strconv.Itoa(len(state.Voters)) + "* (" + signedInt(int64(len(state.Voters)-state.DayVoters)) + ")"
+	message += `\n` + "Started voting: *" + strconv.Itoa(len(state.Gained)) + "*"
+	message += `\n` + "Stopped voting: *" + strconv.Itoa(len(state.Lost)) + "*"
+
+	staked := state.staked() - state.DayStaked
+	sign := ""
+	if staked > 0 {
+		sign = "+"
+	}
+
+	message += `\n` + "Voters' stake: *" + c.FormatStake(state.staked()) + "* (" + sign + c.FormatStake(staked) + ")"
+	message += `\n` + "Vote weight: *" + strconv.FormatFloat(state.Votes, 'f', 0, 64) + "* (" + signedFloat(state.Votes-state.DayVotes) + ")"
+
+	return message
+}
+
+// listVoters names voters with their stake, the largest first
+func listVoters(c *chain.Chain, names []string, stakes map[string]int64) string {
+	sorted := append([]string{}, names...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return stakes[sorted[i]] > stakes[sorted[j]]
+	})
+
+	output := ""
+	for i, name := range sorted {
+		if i == voter_list_max {
+			output += `\n` + "and " + strconv.Itoa(len(sorted)-i) + " more"
+			break
+		}
+
+		output += `\n` + "*" + name + "* with " + c.FormatStake(stakes[name])
+	}
+
+	return output
+}
+
+// sendVoterMessage goes to the users monitoring the producer
+// who picked kind, VotersChanges or VotersDigest, or both
+func sendVoterMessage(c *chain.Chain, users []db.User, producer string, message string, kind string) {
+	for _, user := range users {
+		setting := user.Settings.Voters.Setting
+		if setting != kind && setting != telegram.VotersAll {
+			continue
+		}
+
+		if stringInSlice(producer, chain.AccountsOn(c.Key, user.Accounts)) {
+			telegram.SendMessage(user, message)
+		}
+	}
+}
+
+func wantsVoters(user db.User) bool {
+	setting := user.Settings.Voters.Setting
+	return setting == telegram.VotersAll || setting == telegram.VotersChanges || setting == telegram.VotersDigest
+}
+
+// producerVotes reads every registered producer's total votes
+func producerVotes(c *chain.Chain) (map[string]float64, error) {
+	all, err := getAllProducers(c.Client.Fresh(), c.System)
+	if err != nil {
+		return nil, err
+	}
+
+	votes := make(map[string]float64)
+	for _, p := range all.Producers {
+		votes[p.Owner], _ = strconv.ParseFloat(p.TotalVotes, 64)
+	}
+
+	return votes, nil
+}
+
+func signedInt(n int64) string {
+	if n > 0 {
+		return "+" + strconv.FormatInt(n, 10)
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func signedFloat(f float64) string {
+	if f > 0 {
+		return "+" + strconv.FormatFloat(f, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(f, 'f', 0, 64)
+}
@@ -1,13 +1,15 @@
 package watchman
 
 import (
+	"../chain"
 	"../db"
 	"../telegram"
 	"encoding/json"
-	"github.com/parnurzeal/gorequest"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -18,6 +20,12 @@ const (
 	updateauth_s = "updateauth"
 	deleteauth_s = "deleteauth"
 	unregprod_s  = "unregprod"
+
+	delegatebw_s   = "delegatebw"
+	undelegatebw_s = "undelegatebw"
+	refund_s       = "refund"
+	buyram_s       = "buyram"
+	sellram_s      = "sellram"
 )
 
 var notification_actions_to_watch = map[string][]string{
@@ -31,8 +39,39 @@ var notification_actions_to_watch = map[string][]string{
 		deleteauth_s,
 		unregprod_s,
 	},
+	telegram.NotifyStake: []string{
+		delegatebw_s,
+		undelegatebw_s,
+		refund_s,
+		buyram_s,
+		sellram_s,
+	},
 }
 
+// notify_mu keeps the stream and the poller
+// from delivering notifications at the same time
+var notify_mu sync.Mutex
+
+// hyperion rejects pages above its configured max,
+// the cap bounds a single run after a long downtime
+const (
+	actions_page_size = 1000
+	actions_max_pages = 50
+)
+
+// rows per get_table_rows request, and how many of
+// the newest swaps are checked for missing approvals
+const (
+	table_page_size = 500
+	recent_swaps    = 10
+)
+
+// cursors kept per chain besides each user's own
+const (
+	notification_cursor = "notifications"
+	scheduled_cursor    = "scheduled"
+)
+
 var alert_actions_to_watch = []string{
 	"init", "setprice",
 }
@@ -54,6 +93,12 @@ type action struct {
 	BlockNum       json.Number `json:"block_num, Number"`
 	TrxID          string      `json:"trx_id"`
 	GlobalSequence json.Number `json:"global_sequence, Number"`
+	Receipts       []receipt   `json:"receipts"`
+	Notified       []string    `json:"notified"`
+}
+
+type receipt struct {
+	Receiver string `json:"receiver"`
 }
 
 type act struct {
@@ -85,6 +130,25 @@ type updateauth struct {
 	Account    string `json:"account"`
 }
 
+// stake covers the system contract's staking and ram actions,
+// chains that stake one quantity leave the net and cpu ones empty
+type stake struct {
+	From            string      `json:"from"`
+	Receiver        string      `json:"receiver"`
+	StakeNet        string      `json:"stake_net_quantity"`
+	StakeCpu        string      `json:"stake_cpu_quantity"`
+	StakeQuantity   string      `json:"stake_quantity"`
+	UnstakeNet      string      `json:"unstake_net_quantity"`
+	UnstakeCpu      string      `json:"unstake_cpu_quantity"`
+	UnstakeQuantity string      `json:"unstake_quantity"`
+	Transfer        interface{} `json:"transfer"`
+	Owner           string      `json:"owner"`
+	Payer           string      `json:"payer"`
+	Quant           string      `json:"quant"`
+	Account         string      `json:"account"`
+	Bytes           json.Number `json:"bytes"`
+}
+
 type authorization struct {
 	Actor      string `json:"actor"`
 	Permission string `json:"permission"`
@@ -146,121 +210,379 @@ type swaps struct {
 // 0 initiated, 1 issued (approved), 2 finished (user got the tokens), -1 canceled
 type swap struct {
 	Key               int      `json:"key"`
+	TxID              string   `json:"txid"`
 	SwapTimestamp     string   `json:"swap_timestamp"`
 	Status            int      `json:"status"`
 	ProvidedApprovals []string `json:"provided_approvals"`
 }
 
-func Watch() {
-	var users []db.User
-	var err error
+// SetClient points watchman's default chain at another chain api,
+// such as our own nodes or an in-memory chain.Fake.
+func SetClient(c chain.Client) {
+	chain.Default().Client = c
+}
 
-	users, err = db.GetActiveUsers(telegram.NotifyStop, telegram.AlertStop, telegram.RemindStop)
+// Notify sends notifications for new actions and scheduled
+// transactions and settles the ones still waiting on finality
+func Notify() {
+	users, err := db.GetActiveUsers(telegram.NotifyStop, telegram.AlertStop, telegram.RemindStop)
+	if err != nil {
+		log.Print(err)
+	}
 
+	for _, c := range chain.Chains() {
+		sendNotifications(c, users)
+		confirmNotifications(c)
+	}
+}
+
+// Alert checks block producers, their ranking, keys and swaps
+func Alert() {
+	users, err := db.GetActiveUsers(telegram.NotifyStop, telegram.AlertStop, telegram.RemindStop)
 	if err != nil {
 		log.Print(err)
 	}
 
-	sendNotifications(users)
-	sendAlerts(users)
-	sendReminders(users)
+	for _, c := range chain.Chains() {
+		sendAlerts(c, users)
+		sendRankAlerts(c, users)
+		sendKeyAlerts(c, users)
+	}
 }
 
-func sendNotifications(users []db.User) {
-	today := time.Now()
-	epoch := time.Second * -30
-	epoch_ago := today.Add(epoch)
-	limit := "15000"
+// Remind tells guardians when it is time to vote again
+// and producers when they have rewards to claim
+func Remind() {
+	users, err := db.GetActiveUsers(telegram.NotifyStop, telegram.AlertStop, telegram.RemindStop)
+	if err != nil {
+		log.Print(err)
+	}
+
+	for _, c := range chain.Chains() {
+		sendReminders(c, users)
+		sendRewardReminders(c, users)
+	}
+}
+
+// LogCacheStats reports how often each cached resource
+// was answered without a chain call
+func LogCacheStats() {
+	for _, c := range chain.Chains() {
+		logCacheStats(c)
+	}
+}
+
+func logCacheStats(c *chain.Chain) {
+	if c.Cache == nil {
+		return
+	}
+
+	stats := c.Cache.Stats()
+
+	resources := []string{}
+	for resource := range stats {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+
+	for _, resource := range resources {
+		s := stats[resource]
+		log.Print("cache " + c.Key + ": " + resource + " " + strconv.FormatUint(s.Hits, 10) + " hits, " + strconv.FormatUint(s.Misses, 10) + " misses")
+	}
+}
+
+func sendNotifications(c *chain.Chain, users []db.User) {
+	notify_mu.Lock()
+	defer notify_mu.Unlock()
+
+	// the stream delivers executed actions while it is up
+	if !isStreaming(c) {
+		sendActionNotifications(c, users)
+	}
+
+	sendScheduledNotifications(c, users)
+	sendContractNotifications(c, users)
+	sendProposalNotifications(c, users)
+}
+
+func sendActionNotifications(c *chain.Chain, users []db.User) {
 	action_names := strings.Join(flatten(notification_actions_to_watch), ",")
 	account := ""
 
-	a := getActions(epoch_ago, action_names, limit, account)
-	scheduled_txs := getScheduledTxs(epoch_ago)
+	cursor, started, err := readCursor(c, notification_cursor)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	if started {
+		return
+	}
+
+	a, err := getActions(c.Client, chain.ActionQuery{After: cursorTime(cursor), Names: action_names, Accounts: account})
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	new_actions, next_cursor := pastCursor(cursor, a.Actions)
+
+	if len(new_actions) > 0 {
+		deliverNotifications(c, users, new_actions)
+	}
 
+	if next_cursor != cursor {
+		db.UpdateCursor(next_cursor)
+	}
+}
+
+func sendScheduledNotifications(c *chain.Chain, users []db.User) {
+	scheduled, started, err := readCursor(c, scheduled_cursor)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	if started {
+		return
+	}
+
+	new_actions := []action{}
+	next_scheduled := scheduled
+
+	scheduled_txs, err := getScheduledTxs(c.Client, cursorTime(scheduled))
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	// scheduled transactions have no global sequence yet,
+	// their publish time is the cursor instead
 	for _, tx := range scheduled_txs.Transactions {
+		// same fixed-width format, compares in time order
+		if tx.Published <= scheduled.Timestamp {
+			continue
+		}
+
 		for _, act_data := range tx.TxData.Acts {
 			new_action := action{}
 			new_action.Timestamp = tx.Published
 			new_action.Act = act_data
 			new_action.Act.Scheduled = true
 
-			a.Actions = append(a.Actions, new_action)
+			new_actions = append(new_actions, new_action)
+		}
+
+		if tx.Published > next_scheduled.Timestamp {
+			next_scheduled.Timestamp = tx.Published
 		}
 	}
 
-	if len(a.Actions) > 0 {
+	if len(new_actions) > 0 {
+		deliverNotifications(c, users, new_actions)
+	}
 
-		// avoid duplicate notifications
-		// by saving signatures of [user_id + trx_id]
-		notifications := []string{}
-		var lc time.Time
-		var ts time.Time
-		var err error
+	if next_scheduled != scheduled {
+		db.UpdateCursor(next_scheduled)
+	}
+}
 
-		for _, user := range users {
+// pastCursor keeps the actions the cursor has not reached yet, in sequence order,
+// and returns the cursor moved to the newest of them
+func pastCursor(cursor db.Cursor, list []action) ([]action, db.Cursor) {
+	new_actions := []action{}
+	next_cursor := cursor
+	seen := make(map[uint64]bool)
 
-			// RFC3339 with miliseconds
-			lc, err = time.Parse("2006-01-02T15:04:05.9Z07:00", user.LastCheck)
-			if err != nil {
-				log.Print(err)
-			}
+	// a cursor started at the head has no sequence yet, its time is the bound
+	started := cursor.Sequence == 0 && len(cursor.Timestamp) > 0
 
-			for _, account := range user.Accounts {
-				for _, action := range a.Actions {
+	for _, action := range list {
+		seq := sequenceOf(action)
 
-					notification := user.TelegramID + string(action.GlobalSequence)
+		if started && action.Timestamp <= cursor.Timestamp {
+			continue
+		}
 
-					ts, err = time.Parse("2006-01-02T15:04:05.9", action.Timestamp)
-					if err != nil {
-						log.Print(err)
-					}
+		if seq > cursor.Sequence && !seen[seq] {
+			seen[seq] = true
+			new_actions = append(new_actions, action)
+		}
 
-					within_preference := matchesPreference(user.Settings.Notification.Setting, action.Act.Name)
-					is_new_tx := (ts.After(lc) && !stringInSlice(notification, notifications))
-					account_match := actorIsInAuth(action.Act.Authorizations, account)
+		if seq > next_cursor.Sequence {
+			next_cursor.Sequence = seq
+			next_cursor.BlockNum, _ = strconv.ParseUint(string(action.BlockNum), 10, 64)
+			next_cursor.Timestamp = action.Timestamp
+		}
+	}
 
-					if account_match && is_new_tx && within_preference {
-						var action_name string
+	sort.SliceStable(new_actions, func(i, j int) bool {
+		return sequenceOf(new_actions[i]) < sequenceOf(new_actions[j])
+	})
 
-						if action.Act.Scheduled {
-							action_name = "scheduled " + action.Act.Name
-						} else {
-							action_name = action.Act.Name
-						}
+	return new_actions, next_cursor
+}
 
-						message := "_" + time.Now().Format("Mon Jan _2 15:04 2006 UTC") + "_"
-						message += `\n` + "Account *" + account + "* has a new *" + action_name + "* transaction."
+// deliverNotifications routes each action through the subscriber index
+// to the users monitoring one of its actors and sends it past each
+// user's own cursor, the cursor is saved right after a message so
+// a crash in the middle of a batch never repeats a notification
+func deliverNotifications(c *chain.Chain, users []db.User, new_actions []action) {
+	cursors, err := db.GetCursors(c.Key)
+	if err != nil {
+		log.Print(err)
+		return
+	}
 
-						message_body := parseData(action.Act.Data, action.Act.Name)
-						if len(message_body) > 0 {
-							message += `\n\n` + message_body
-						}
+	// without a known LIB every block counts as reversible
+	lib, err := lastIrreversible(c.Client)
+	if err != nil {
+		log.Print(err)
+	}
 
-						message += `\n\n` + "[View on Remme Explorer](https://remchain.remme.io/transaction/" + action.TrxID + ")"
+	active := make(map[string]db.User, len(users))
+	for _, user := range users {
+		active[user.TelegramID] = user
+	}
 
-						notifications = append(notifications, notification)
-						telegram.SendMessage(user, message)
-					}
-				}
+	for _, action := range new_actions {
+		seq := sequenceOf(action)
+		order, matched := routeAction(c, active, action)
+
+		for _, telegram_id := range order {
+			user := active[telegram_id]
+
+			user_cursor, ok := cursors[telegram_id]
+			if !ok {
+				user_cursor = db.Cursor{Chain: c.Key, Owner: telegram_id}
+			}
+
+			// scheduled transactions are deduplicated by the chain cursor
+			if !action.Act.Scheduled && seq <= user_cursor.Sequence {
+				continue
+			}
+
+			if !matchesPreference(user.Settings.Notification.Setting, action.Act.Name) {
+				continue
+			}
+
+			for _, account := range matched[telegram_id] {
+				sendNotification(c, user, renderNotification(c, account, action), action, lib)
+			}
+
+			if !action.Act.Scheduled {
+				user_cursor.Sequence = seq
+				user_cursor.BlockNum, _ = strconv.ParseUint(string(action.BlockNum), 10, 64)
+				user_cursor.Timestamp = action.Timestamp
+
+				cursors[telegram_id] = user_cursor
+				db.UpdateCursor(user_cursor)
+			}
+		}
+	}
+}
+
+// routeAction lists the active subscribers of an action in the order
+// their accounts appear in it, each with the accounts it matched
+func routeAction(c *chain.Chain, active map[string]db.User, action action) ([]string, map[string][]string) {
+	order := []string{}
+	matched := make(map[string][]string)
+
+	for _, account := range involvedAccounts(action) {
+		for _, telegram_id := range subscribers.lookup(chain.Scope(c.Key, account)) {
+			if _, ok := active[telegram_id]; !ok {
+				continue
+			}
+
+			if _, ok := matched[telegram_id]; !ok {
+				order = append(order, telegram_id)
 			}
 
-			user.LastCheck = ts.Format("2006-01-02T15:04:05.9Z07:00")
-			db.UpdateLastCheck(user.TelegramID, user.LastCheck)
+			matched[telegram_id] = append(matched[telegram_id], account)
 		}
 	}
+
+	return order, matched
+}
+
+// sendNotification marks executed actions with their block's finality
+// and keeps reversible ones for confirmNotifications to settle
+func sendNotification(c *chain.Chain, user db.User, message string, action action, lib uint64) {
+	// pending scheduled transactions are in no block yet
+	if action.Act.Scheduled {
+		telegram.SendMessage(user, message)
+		return
+	}
+
+	block_num, _ := strconv.ParseUint(string(action.BlockNum), 10, 64)
+	message_id := telegram.SendMessage(user, message+blockStatus(block_num, lib))
+
+	if block_num > lib && len(message_id) > 0 {
+		db.InsertPending(db.Pending{
+			Chain:      c.Key,
+			TelegramID: user.TelegramID,
+			MessageID:  message_id,
+			BlockNum:   block_num,
+			TrxID:      action.TrxID,
+			Text:       message,
+		})
+	}
+}
+
+func renderNotification(c *chain.Chain, account string, action action) string {
+	var action_name string
+
+	if action.Act.Scheduled {
+		action_name = "scheduled " + action.Act.Name
+	} else {
+		action_name = action.Act.Name
+	}
+
+	message := "_" + time.Now().Format("Mon Jan _2 15:04 2006 UTC") + "_"
+
+	if t, ok := transferOf(action); ok && account == t.To && account != t.From {
+		message += `\n` + "Account " + accountLabel(c, account) + " received *" + t.Quantity + "* from *" + t.From + "*."
+	} else if ok && account == t.From && account != t.To {
+		message += `\n` + "Account " + accountLabel(c, account) + " sent *" + t.Quantity + "* to *" + t.To + "*."
+	} else {
+		message += `\n` + "Account " + accountLabel(c, account) + " has a new *" + action_name + "* transaction."
+	}
+
+	message_body := parseData(c, action.Act)
+	if len(message_body) > 0 {
+		message += `\n\n` + message_body
+	}
+
+	if len(c.Explorer) > 0 {
+		message += `\n\n` + "[View on " + c.ExplorerName + "](" + c.Explorer + action.TrxID + ")"
+	}
+
+	return message
 }
 
-func sendAlerts(users []db.User) {
+func sendAlerts(c *chain.Chain, users []db.User) {
 	var last_block_time time.Time
 	var last_alert time.Time
 	var top_21_chosen_time time.Time
 	var snooze time.Time
 	var bp_chosen_time time.Time
 	var most_recent_init time.Time
-	var err error
 
-	p := getProducers()
-	s := getSwaps()
+	// a lagging node reports stale last_block_time values,
+	// never raise producer alerts from one
+	fresh := c.Client.Fresh()
+
+	p, err := getProducers(fresh, c.System)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	s, err := getSwaps(fresh, c.Swap)
+	if err != nil {
+		log.Print(err)
+		return
+	}
 
 	today := time.Now()
 
@@ -290,7 +612,6 @@ func sendAlerts(users []db.User) {
 	missed_setprice := producers{}
 
 	actions_cutoff := today.Add(time.Hour * -12)
-	limit := "15000"
 	action_names := strings.Join(alert_actions_to_watch, ",")
 
 	for _, producer := range p.Producers {
@@ -300,7 +621,17 @@ func sendAlerts(users []db.User) {
 	}
 
 	all_producers_s := strings.Join(all_producers, ",")
-	a := getActions(actions_cutoff, action_names, limit, all_producers_s)
+	a := actions{}
+
+	// only chains running the oracle or swap contracts have these duties
+	if len(c.Oracle) > 0 || len(c.Swap) > 0 {
+		a, err = getActions(fresh, chain.ActionQuery{After: actions_cutoff, Names: action_names, Accounts: all_producers_s})
+		if err != nil {
+			log.Print(err)
+			return
+		}
+	}
+
 	negative_two_hours := time.Hour * -2
 	two_hours_ago := today.Add(negative_two_hours)
 	// add a buffer of 10 minutes
@@ -331,7 +662,7 @@ func sendAlerts(users []db.User) {
 
 				// make sure that our data actually contains a setprice action
 				// before we hold producers accountable for missing it
-				if action.Act.Name == "setprice" && action.Act.Account == "rem.oracle" && ts.After(two_hours_ago) {
+				if action.Act.Name == "setprice" && action.Act.Account == c.Oracle && ts.After(two_hours_ago) {
 					setprice_exists = true
 				}
 
@@ -341,7 +672,7 @@ func sendAlerts(users []db.User) {
 
 					// setprice occurs most frequently
 					// check by timestamp within the last 2 hours
-					if action.Act.Name == "setprice" && action.Act.Account == "rem.oracle" && ts.After(two_hours_ago) {
+					if action.Act.Name == "setprice" && action.Act.Account == c.Oracle && ts.After(two_hours_ago) {
 						found_setprice = true
 					}
 
@@ -349,7 +680,7 @@ func sendAlerts(users []db.User) {
 					// and after our actions_cutoff duration
 					if most_recent_init.After(actions_cutoff) && most_recent_init.After(bp_chosen_time) {
 						// check if init action happened after most recent swap
-						if action.Act.Name == "init" && action.Act.Account == "rem.swap" && ts.After(most_recent_init) {
+						if action.Act.Name == "init" && action.Act.Account == c.Swap && ts.After(most_recent_init) {
 							found_init = true
 						}
 					} else {
@@ -389,6 +720,7 @@ func sendAlerts(users []db.User) {
 
 			if time_for_new_alert && not_snoozing {
 
+				accounts := chain.AccountsOn(c.Key, user.Accounts)
 				missed_blocks := producers{}
 				filtered_producers := producers{}
 				filtered_missed_init := producers{}
@@ -397,19 +729,19 @@ func sendAlerts(users []db.User) {
 				if user.Settings.Alert.Setting == "Alert only when my producer fails" {
 
 					for _, producer := range p.Producers {
-						if stringInSlice(producer.Owner, user.Accounts) {
+						if stringInSlice(producer.Owner, accounts) {
 							filtered_producers.Producers = append(filtered_producers.Producers, producer)
 						}
 					}
 
 					for _, producer := range missed_init.Producers {
-						if stringInSlice(producer.Owner, user.Accounts) {
+						if stringInSlice(producer.Owner, accounts) {
 							filtered_missed_init.Producers = append(filtered_missed_init.Producers, producer)
 						}
 					}
 
 					for _, producer := range missed_setprice.Producers {
-						if stringInSlice(producer.Owner, user.Accounts) {
+						if stringInSlice(producer.Owner, accounts) {
 							filtered_missed_setprice.Producers = append(filtered_missed_setprice.Producers, producer)
 						}
 					}
@@ -450,7 +782,7 @@ func sendAlerts(users []db.User) {
 				// missed blocks
 				if has_missed_blocks {
 					block_message := "_" + time.Now().Format("Mon Jan _2 15:04 2006 UTC") + "_"
-					block_message += `\n` + "The following block producers are missing blocks:"
+					block_message += `\n` + "The following block producers" + chainLabel(c) + " are missing blocks:"
 
 					for _, bp := range missed_blocks.Producers {
 						last_block_time, err = time.Parse("2006-01-02T15:04:05.9", bp.LastBlockTime)
@@ -475,7 +807,7 @@ func sendAlerts(users []db.User) {
 				// missed init
 				if has_missed_init {
 					init_message := "_" + time.Now().Format("Mon Jan _2 15:04 2006 UTC") + "_"
-					init_message += `\n` + "The following block producers are missing an `init` action, from last 12 hours:"
+					init_message += `\n` + "The following block producers" + chainLabel(c) + " are missing an `init` action, from last 12 hours:"
 
 					for _, bp := range filtered_missed_init.Producers {
 						init_message += `\n` + "*" + bp.Owner + "*"
@@ -487,7 +819,7 @@ func sendAlerts(users []db.User) {
 				// missed setprice
 				if has_missed_setprice {
 					setprice_message := "_" + time.Now().Format("Mon Jan _2 15:04 2006 UTC") + "_"
-					setprice_message += `\n` + "The following block producers are missing a `setprice` action, from last 2 hours:"
+					setprice_message += `\n` + "The following block producers" + chainLabel(c) + " are missing a `setprice` action, from last 2 hours:"
 
 					for _, bp := range filtered_missed_setprice.Producers {
 						setprice_message += `\n` + "*" + bp.Owner + "*"
@@ -505,11 +837,19 @@ func sendAlerts(users []db.User) {
 	}
 }
 
-func sendReminders(users []db.User) {
+func sendReminders(c *chain.Chain, users []db.User) {
 	var lr time.Time
-	var err error
 
-	v := getVoters()
+	// guardians are a REM feature
+	if c.GuardianStake == 0 {
+		return
+	}
+
+	v, err := getVoters(c)
+	if err != nil {
+		log.Print(err)
+		return
+	}
 
 	for _, user := range users {
 		// RFC3339 with miliseconds
@@ -520,7 +860,7 @@ func sendReminders(users []db.User) {
 
 		if user.Settings.Reminder.Setting != "Stop all reminders" {
 			for _, voter := range v.Voters {
-				if stringInSlice(voter.Owner, user.Accounts) {
+				if stringInSlice(voter.Owner, chain.AccountsOn(c.Key, user.Accounts)) {
 					var last_vote time.Time
 
 					last_vote, err = time.Parse("2006-01-02T15:04:05.9", voter.LastReassertionTime)
@@ -557,56 +897,77 @@ func sendReminders(users []db.User) {
 	}
 }
 
-func getActions(epoch_ago time.Time, action_names string, limit string, account string) actions {
-	var body string
-	var err error
-	// convert timestamp to ISO8601 for Hyperion
-	after := epoch_ago.Format("2006-01-02T15:04:05")
+// getActions pages through hyperion until the window is exhausted.
+// Each page starts after the block time of the previous page's last action,
+// after is whole seconds so only what was read within that second is skipped
+// and anything read again is dropped by global sequence.
+// Actions are appended as the chain grows, only a short page ends the window.
+func getActions(c chain.Client, q chain.ActionQuery) (actions, error) {
+	all := actions{}
+	seen := make(map[uint64]bool)
 
-	// account is optional
-	if len(account) > 0 {
-		account = "act.authorization.actor=" + account + "&"
-	}
+	q.Limit = actions_page_size
+	q.Skip = 0
 
-	url := "https://rem.eon.llc/v2/history/get_actions?action_ordinal=1&" + account + "act.name=" + action_names + "&limit=" + limit + "&sort=asc&after=" + after
+	for page := 1; ; page++ {
+		a := actions{}
 
-	request := gorequest.New()
-	_, body, errs := request.Get(url).End()
+		err := c.GetActions(q, &a)
+		if err != nil {
+			return all, err
+		}
 
-	if errs != nil {
-		log.Print(errs)
-	}
+		all.QueryTime += a.QueryTime
+		all.Total = a.Total
 
-	a := actions{}
+		for _, action := range a.Actions {
+			seq := sequenceOf(action)
 
-	err = json.Unmarshal([]byte(body), &a)
-	if err != nil {
-		log.Print(err)
-	}
+			if !seen[seq] {
+				seen[seq] = true
+				all.Actions = append(all.Actions, action)
+			}
+		}
 
-	return a
-}
+		// a short page means there is nothing left in the window
+		if len(a.Actions) < actions_page_size {
+			break
+		}
 
-func getProducers() producers {
-	var body string
-	var err error
-	// convert timestamp to ISO8601 for Hyperion
-	url := "http://rem.eon.llc/v1/chain/get_table_rows"
-	data := `{"table":"producers","scope":"rem","code":"rem", "limit": 1000, "json":true}`
+		if page == actions_max_pages {
+			log.Print("get_actions: stopped at the " + strconv.Itoa(actions_max_pages) + " page cap with " + strconv.Itoa(len(all.Actions)) + " of " + strconv.Itoa(a.Total.Value) + " actions, the rest is left for the next run")
+			break
+		}
+
+		last := a.Actions[len(a.Actions)-1]
+		ts, err := time.Parse("2006-01-02T15:04:05.9", last.Timestamp)
+		if err != nil {
+			return all, err
+		}
 
-	request := gorequest.New()
-	_, body, errs := request.Post(url).Send(data).End()
+		q.After = ts.Truncate(time.Second)
+		q.Skip = 0
+
+		for i := len(all.Actions) - 1; i >= 0; i-- {
+			read, _ := time.Parse("2006-01-02T15:04:05.9", all.Actions[i].Timestamp)
+			if read.Before(q.After) {
+				break
+			}
+			q.Skip++
+		}
 
-	if errs != nil {
-		log.Print(errs)
+		log.Print("get_actions: page " + strconv.Itoa(page) + " full at " + strconv.Itoa(len(a.Actions)) + " actions, continuing after " + last.Timestamp)
 	}
 
-	all := producers{}
+	return all, nil
+}
+
+func getProducers(c chain.Client, system string) (producers, error) {
 	relevant := producers{}
 
-	err = json.Unmarshal([]byte(body), &all)
+	all, err := getAllProducers(c, system)
 	if err != nil {
-		log.Print(err)
+		return relevant, err
 	}
 
 	// those who have never produced a block
@@ -617,64 +978,61 @@ func getProducers() producers {
 		}
 	}
 
-	return relevant
+	return relevant, err
 }
 
-func getVoters() voters {
-	var body string
-	var err error
-	var staked uint64
+// getAllProducers reads every registered producer, active or not
+func getAllProducers(c chain.Client, system string) (producers, error) {
+	q := chain.TableQuery{Code: system, Scope: system, Table: "producers", Limit: table_page_size}
+	all := producers{}
 
-	url := "http://rem.eon.llc/v1/chain/get_table_rows"
-	data := `{"table":"voters","scope":"rem","code":"rem","limit": 1000,"json":true}`
+	err := chain.ReadTable(c, q, 0, &all)
 
-	request := gorequest.New()
-	_, body, errs := request.Post(url).Send(data).End()
+	return all, err
+}
 
-	if errs != nil {
-		log.Print(errs)
-	}
+func getVoters(c *chain.Chain) (voters, error) {
+	var err error
+	var staked uint64
+
+	q := chain.TableQuery{Code: c.System, Scope: c.System, Table: "voters", Limit: table_page_size}
 
 	all := voters{}
 	active := voters{}
 
-	err = json.Unmarshal([]byte(body), &all)
+	err = chain.ReadTable(c.Client, q, 0, &all)
 	if err != nil {
-		log.Print(err)
+		return active, err
 	}
 
 	// remove anyone below stake requirement
 	for _, voter := range all.Voters {
 		staked, _ = strconv.ParseUint(string(voter.Staked), 10, 64)
 
-		if staked >= 2500000000 {
+		if staked >= c.GuardianStake {
 			active.Voters = append(active.Voters, voter)
 		}
 	}
 
-	return active
+	return active, err
 }
 
-func getSwaps() swaps {
-	var body string
+func getSwaps(c chain.Client, swap_contract string) (swaps, error) {
 	var err error
 
-	url := "http://rem.eon.llc/v1/chain/get_table_rows"
-	data := `{"table":"swaps","scope":"rem.swap","code":"rem.swap","reverse":true,"limit":10,"json":true}`
-
-	request := gorequest.New()
-	_, body, errs := request.Post(url).Send(data).End()
-
-	if errs != nil {
-		log.Print(errs)
-	}
+	// only the most recent swaps are of interest
+	q := chain.TableQuery{Code: swap_contract, Scope: swap_contract, Table: "swaps", Reverse: true, Limit: recent_swaps}
 
 	all := swaps{}
 	valid := swaps{}
 
-	err = json.Unmarshal([]byte(body), &all)
+	if len(swap_contract) == 0 {
+		return valid, err
+	}
+
+	err = chain.ReadTable(c, q, recent_swaps, &all)
 	if err != nil {
-		log.Print(err)
+		return valid, err
 	}
 
 	// those who have never produced a block
@@ -685,32 +1043,125 @@ func getSwaps() swaps {
 		}
 	}
 
-	return valid
+	return valid, err
 }
 
-func getScheduledTxs(epoch_ago time.Time) transactions {
-	var body string
-	var err error
-	// convert timestamp to ISO8601 for Hyperion
-	after := epoch_ago.Format("2006-01-02T15:04:05")
-	url := "https://rem.eon.llc/v1/chain/get_scheduled_transactions"
-	data := `{"json":"true", "limit": 1000, "lower_bound": "` + after + `"}`
+func getScheduledTxs(c chain.Client, epoch_ago time.Time) (transactions, error) {
+	t := transactions{}
 
-	request := gorequest.New()
-	_, body, errs := request.Post(url).Send(data).End()
+	err := c.GetScheduledTransactions(epoch_ago, 1000, &t)
+
+	return t, err
+}
+
+// accountLabel names an account in messages,
+// with its chain unless it is on the default one
+func accountLabel(c *chain.Chain, account string) string {
+	return "*" + account + "*" + chainLabel(c)
+}
 
-	if errs != nil {
-		log.Print(errs)
+func chainLabel(c *chain.Chain) string {
+	if c == chain.Default() {
+		return ""
 	}
+	return " on *" + c.Name + "*"
+}
 
-	t := transactions{}
+func sequenceOf(a action) uint64 {
+	seq, _ := strconv.ParseUint(string(a.GlobalSequence), 10, 64)
+	return seq
+}
+
+// readCursor reads a cursor, one never saved is set to the chain's
+// head so the first run starts from now instead of replaying history.
+// started tells the cursor was just set and there is nothing to read yet.
+func readCursor(c *chain.Chain, owner string) (db.Cursor, bool, error) {
+	cursor, err := db.GetCursor(c.Key, owner)
+	if err != nil || len(cursor.Timestamp) > 0 {
+		return cursor, false, err
+	}
+
+	i := chainInfo{}
+
+	err = c.Client.GetInfo(&i)
+	if err != nil {
+		return cursor, false, err
+	}
+
+	cursor.BlockNum = i.HeadBlockNum
+	cursor.Timestamp = i.HeadBlockTime
+	db.UpdateCursor(cursor)
 
-	err = json.Unmarshal([]byte(body), &t)
+	return cursor, true, nil
+}
+
+// cursorTime is where the next history query starts,
+// a second early since hyperion compares whole seconds
+func cursorTime(c db.Cursor) time.Time {
+	if len(c.Timestamp) == 0 {
+		return time.Now().Add(time.Second * -30)
+	}
+
+	ts, err := time.Parse("2006-01-02T15:04:05.9", c.Timestamp)
 	if err != nil {
 		log.Print(err)
+		return time.Now().Add(time.Second * -30)
+	}
+
+	return ts.Add(time.Second * -1)
+}
+
+// involvedAccounts lists, once each, the accounts an action concerns:
+// its actors, receivers and notified accounts and the
+// from, to and receiver of transfer-like and staking data
+func involvedAccounts(action action) []string {
+	accounts := []string{}
+
+	add := func(account string) {
+		if len(account) > 0 && !stringInSlice(account, accounts) {
+			accounts = append(accounts, account)
+		}
+	}
+
+	for _, authorization := range action.Act.Authorizations {
+		add(authorization.Actor)
+	}
+
+	for _, r := range action.Receipts {
+		add(r.Receiver)
+	}
+
+	for _, account := range action.Notified {
+		add(account)
 	}
 
-	return t
+	for _, field := range []string{"from", "to", "receiver"} {
+		if account, ok := action.Act.Data[field].(string); ok {
+			add(account)
+		}
+	}
+
+	return accounts
+}
+
+// transferOf reads the data of actions that move a quantity
+// from one account to another, whatever their name
+func transferOf(action action) (transfer, bool) {
+	t := transfer{}
+
+	from, from_ok := action.Act.Data["from"].(string)
+	to, to_ok := action.Act.Data["to"].(string)
+	quantity, quantity_ok := action.Act.Data["quantity"].(string)
+
+	if !from_ok || !to_ok || !quantity_ok {
+		return t, false
+	}
+
+	t.From = from
+	t.To = to
+	t.Quantity = quantity
+
+	return t, true
 }
 
 func actorIsInAuth(authorizations []authorization, actor string) bool {
@@ -729,15 +1180,22 @@ func matchesPreference(preference string, action_name string) bool {
 		return true
 	} else if preference == telegram.NotifyChanges && stringInSlice(action_name, notification_actions_to_watch[telegram.NotifyChanges]) {
 		return true
+	} else if preference == telegram.NotifyStake && stringInSlice(action_name, notification_actions_to_watch[telegram.NotifyStake]) {
+		return true
 	}
 
 	return false
 }
 
-func parseData(data map[string]interface{}, action_name string) string {
+// parseData renders an action's fields, the actions we know get
+// curated formatters and any other is rendered from its contract's abi
+func parseData(c *chain.Chain, a act) string {
 	var output string
 	var err error
 
+	data := a.Data
+	action_name := a.Name
+
 	jsonString, _ := json.Marshal(data)
 
 	if stringInSlice(action_name, notification_actions_to_watch[telegram.NotifyTransfers]) {
@@ -785,6 +1243,66 @@ func parseData(data map[string]interface{}, action_name string) string {
 			output = ""
 
 		}
+	} else if stringInSlice(action_name, notification_actions_to_watch[telegram.NotifyStake]) {
+
+		s := stake{}
+
+		err = json.Unmarshal(jsonString, &s)
+		if err != nil {
+			log.Print(err)
+		}
+
+		output = renderStake(action_name, s)
+
+	} else {
+		output = renderFields(c, a)
+	}
+
+	return output
+}
+
+func renderStake(action_name string, s stake) string {
+	var output string
+
+	line := func(label string, value string) {
+		if len(value) == 0 {
+			return
+		}
+
+		if len(output) > 0 {
+			output += `\n`
+		}
+
+		output += label + ": *" + value + "*"
+	}
+
+	switch action_name {
+	case delegatebw_s:
+		line("From", s.From)
+		line("Receiver", s.Receiver)
+		line("Stake", s.StakeQuantity)
+		line("Net", s.StakeNet)
+		line("CPU", s.StakeCpu)
+
+		// staked tokens are the receiver's to unstake once transferred
+		if transferred, _ := s.Transfer.(bool); transferred || s.Transfer == float64(1) {
+			line("Transfer", "yes")
+		}
+	case undelegatebw_s:
+		line("From", s.From)
+		line("Receiver", s.Receiver)
+		line("Unstake", s.UnstakeQuantity)
+		line("Net", s.UnstakeNet)
+		line("CPU", s.UnstakeCpu)
+	case refund_s:
+		line("Owner", s.Owner)
+	case buyram_s:
+		line("Payer", s.Payer)
+		line("Receiver", s.Receiver)
+		line("Quantity", s.Quant)
+	case sellram_s:
+		line("Account", s.Account)
+		line("Bytes", s.Bytes.String())
 	}
 
 	return output
@@ -0,0 +1,238 @@
+package watchman
+
+import (
+	"../chain"
+	"../db"
+	"../telegram"
+	"github.com/DATA-DOG/go-sqlmock"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// botAPI stands in for telegram, it keeps every message sent
+type botAPI struct {
+	mu       sync.Mutex
+	server   *httptest.Server
+	messages []string
+}
+
+func newBotAPI(t testing.TB) *botAPI {
+	bot := &botAPI{}
+
+	bot.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		bot.mu.Lock()
+		bot.messages = append(bot.messages, string(body))
+		bot.mu.Unlock()
+
+		w.Write([]byte(`{"ok":true,"result":{"message_id":7}}`))
+	}))
+
+	telegram.SetAPI(bot.server.URL)
+	t.Cleanup(bot.server.Close)
+
+	return bot
+}
+
+func (bot *botAPI) sent() []string {
+	bot.mu.Lock()
+	defer bot.mu.Unlock()
+
+	return append([]string{}, bot.messages...)
+}
+
+// useFake runs the default chain on a fake client and the db on a mock
+func useFake(t testing.TB) (*chain.Chain, *chain.Fake, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.Use(conn)
+	t.Cleanup(func() { conn.Close() })
+
+	c := chain.Default()
+	client := c.Client
+	t.Cleanup(func() { c.Client = client })
+
+	fake := chain.NewFake()
+	c.Client = fake
+
+	// the index is loaded from the mock on first use
+	subscribers = &subscriberIndex{}
+
+	return c, fake, mock
+}
+
+func transferAction(seq string, block string, from string, to string) map[string]interface{} {
+	return map[string]interface{}{
+		"@timestamp":      "2026-10-17T10:00:05.000",
+		"block_num":       block,
+		"trx_id":          "trx" + seq,
+		"global_sequence": seq,
+		"act": map[string]interface{}{
+			"account":       "rem.token",
+			"name":          transfer_s,
+			"authorization": []map[string]string{{"actor": from, "permission": "active"}},
+			"data":          map[string]interface{}{"from": from, "to": to, "quantity": "1.0000 REM", "memo": ""},
+		},
+		"receipts": []map[string]string{{"receiver": "rem.token"}, {"receiver": from}, {"receiver": to}},
+		"notified": []string{"rem.token", from, to},
+	}
+}
+
+func TestSendActionNotifications(t *testing.T) {
+	c, fake, mock := useFake(t)
+	bot := newBotAPI(t)
+
+	fake.SetHead(130, 110)
+	fake.AddActions(
+		transferAction("10", "119", "alice", "bob"),
+		transferAction("11", "120", "alice", "bob"),
+		transferAction("12", "121", "carol", "dave"),
+	)
+
+	user := db.User{TelegramID: "42", Accounts: []string{"alice"}}
+	user.Settings.Notification.Setting = telegram.NotifyAll
+
+	mock.ExpectQuery("SELECT sequence, block_num, timestamp").
+		WillReturnRows(sqlmock.NewRows([]string{"sequence", "block_num", "timestamp"}).AddRow(10, 119, "2026-10-17T10:00:05.000"))
+	mock.ExpectQuery("SELECT owner, sequence, block_num, timestamp").
+		WillReturnRows(sqlmock.NewRows([]string{"owner", "sequence", "block_num", "timestamp"}))
+	mock.ExpectQuery("SELECT telegram_id, accounts").
+		WillReturnRows(sqlmock.NewRows([]string{"telegram_id", "accounts"}).AddRow("42", "{alice}"))
+	mock.ExpectExec(`INSERT INTO .*\(chain, telegram_id, message_id`).
+		WithArgs(c.Key, "42", "7", uint64(120), "trx11", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO .*\(chain, owner, sequence`).
+		WithArgs(c.Key, "42", uint64(11), uint64(120), "2026-10-17T10:00:05.000").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO .*\(chain, owner, sequence`).
+		WithArgs(c.Key, notification_cursor, uint64(12), uint64(121), "2026-10-17T10:00:05.000").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	sendActionNotifications(c, []db.User{user})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := bot.sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want 1: %v", len(sent), sent)
+	}
+
+	for _, want := range []string{`"chat_id":"42"`, "*1.0000 REM*", "*bob*", "still reversible"} {
+		if !strings.Contains(sent[0], want) {
+			t.Errorf("message lacks %s: %s", want, sent[0])
+		}
+	}
+}
+
+// approved levels leave requested_approvals for provided_approvals
+func TestLoadProposalCountsProvidedApprovals(t *testing.T) {
+	c, fake, _ := useFake(t)
+
+	fake.SetTable(c.Msig, "alice", "approvals2", map[string]interface{}{
+		"proposal_name": "upgrade",
+		"requested_approvals": []interface{}{
+			map[string]interface{}{"level": map[string]string{"actor": "carol", "permission": "active"}, "time": "1970-01-01T00:00:00.000"},
+		},
+		"provided_approvals": []interface{}{
+			map[string]interface{}{"level": map[string]string{"actor": "bob", "permission": "active"}, "time": "2026-10-17T10:00:05.000"},
+		},
+	})
+
+	p, err := loadProposal(c, "alice", "upgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(p.Requested, ",") != "carol@active,bob@active" || strings.Join(p.Approved, ",") != "bob@active" {
+		t.Fatalf("requested %v, approved %v", p.Requested, p.Approved)
+	}
+
+	message := renderProposal(c, p)
+	if !strings.Contains(message, "Approvals: *1 of 2*") || !strings.Contains(message, "\xe2\x9c\x85 bob@active") {
+		t.Errorf("unexpected message %s", message)
+	}
+}
+
+// a window longer than a page is read once through, seconds holding
+// several hundred actions straddle the page boundaries
+func TestGetActionsPagesByTime(t *testing.T) {
+	_, fake, _ := useFake(t)
+
+	const total = 2500
+	start := time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)
+
+	for i := 0; i < total; i++ {
+		a := transferAction(strconv.Itoa(i+1), strconv.Itoa(i/300+1), "alice", "bob")
+		a["@timestamp"] = start.Add(time.Second * time.Duration(i/300)).Add(time.Millisecond * time.Duration(i%300)).Format("2006-01-02T15:04:05.000")
+		fake.AddActions(a)
+	}
+
+	a, err := getActions(fake, chain.ActionQuery{After: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a.Actions) != total {
+		t.Fatalf("read %d actions, want %d", len(a.Actions), total)
+	}
+
+	for i, action := range a.Actions {
+		if sequenceOf(action) != uint64(i+1) {
+			t.Fatalf("action %d has sequence %d", i, sequenceOf(action))
+		}
+	}
+}
+
+// a key changed while its producer was inactive is reported on its return
+func TestKeyChangeWhileInactive(t *testing.T) {
+	c, fake, mock := useFake(t)
+	bot := newBotAPI(t)
+
+	user := db.User{TelegramID: "42", Accounts: []string{"alice"}}
+	user.Settings.Alert.Setting = telegram.AlertAll
+
+	expectState := func(before string, after string) {
+		rows := sqlmock.NewRows([]string{"value"})
+		if len(before) > 0 {
+			rows.AddRow([]byte(before))
+		}
+
+		mock.ExpectQuery("SELECT value").WithArgs(c.Key, key_state).WillReturnRows(rows)
+		mock.ExpectExec(`INSERT INTO .*\(chain, key, value\)`).
+			WithArgs(c.Key, key_state, []byte(after)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	// unregistered, the chain clears the key
+	fake.SetTable(c.System, c.System, "producers", producer{Owner: "alice", ProducerKey: "EOS1111111111111111111111111111111114T1Anm", IsActive: 0})
+	expectState(`{"alice":{"key":"EOS_A","punished_until":""}}`, `{"alice":{"key":"EOS_A","punished_until":""}}`)
+
+	sendKeyAlerts(c, []db.User{user})
+
+	// registered again with another key
+	fake.SetTable(c.System, c.System, "producers", producer{Owner: "alice", ProducerKey: "EOS_B", IsActive: 1})
+	expectState(`{"alice":{"key":"EOS_A","punished_until":""}}`, `{"alice":{"key":"EOS_B","punished_until":""}}`)
+
+	sendKeyAlerts(c, []db.User{user})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := bot.sent()
+	if len(sent) != 1 || !strings.Contains(sent[0], "from `EOS_A` to `EOS_B`") {
+		t.Errorf("sent %v, want one key change", sent)
+	}
+}